@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ackToken is the signed content of an acknowledgement link.
+type ackToken struct {
+	Alert    string `json:"a"`
+	Severity string `json:"s"`
+	Expires  int64  `json:"e"`
+}
+
+// ackLinks creates and verifies acknowledgement links. A link contains the alert and its severity and is signed
+// with HMAC-SHA256, so opening it acknowledges the alert without logging in.
+type ackLinks struct {
+	secret   []byte
+	baseURL  string
+	validity time.Duration
+}
+
+func NewAckLinks(secret, baseURL string, validity time.Duration) *ackLinks {
+	return &ackLinks{
+		secret:   []byte(secret),
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		validity: validity,
+	}
+}
+
+func (l *ackLinks) URL(labels alertLabels, now time.Time) (string, error) {
+	payload, err := json.Marshal(ackToken{Alert: labels.Key(), Severity: labels.Severity, Expires: now.Add(l.validity).Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(l.sign(payload))
+	return l.baseURL + "/acknowledge?token=" + url.QueryEscape(token), nil
+}
+
+func (l *ackLinks) Verify(token string, now time.Time) (ackToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ackToken{}, fmt.Errorf("Invalid token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ackToken{}, fmt.Errorf("Invalid token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, l.sign(payload)) {
+		return ackToken{}, fmt.Errorf("Invalid token")
+	}
+
+	var t ackToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return ackToken{}, fmt.Errorf("Invalid token")
+	}
+	if now.Unix() > t.Expires {
+		return ackToken{}, fmt.Errorf("This link has expired")
+	}
+
+	return t, nil
+}
+
+func (l *ackLinks) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// addAckLink adds an acknowledgement link for the alert to msg.
+func (a *alertServer) addAckLink(msg *NotificationMessage, labels alertLabels, now time.Time) {
+	if a.ackLinks == nil {
+		return
+	}
+
+	link, err := a.ackLinks.URL(labels, now)
+	if err != nil {
+		log.Println("Error creating acknowledgement link: ", err)
+		return
+	}
+
+	msg.AcknowledgeURL = link
+	if msg.Format == formatMarkdown {
+		msg.Message += "\n\n[Acknowledge this alert](" + link + ")"
+	} else {
+		msg.Message += "\n\nAcknowledge this alert: " + link
+	}
+}
+
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Acknowledge alert</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>
+{{else if .Acknowledged}}<p>Alert {{.Rule}} for service instance {{.Instance}} is acknowledged. You won't get reminders or escalations for it until it resolves{{if .Severity}} or its severity changes{{end}}.</p>
+{{else}}<p>Acknowledge alert {{.Rule}} for service instance {{.Instance}}?</p>
+<form method="post"><input type="hidden" name="token" value="{{.Token}}"><button type="submit">Acknowledge</button></form>
+{{end}}</body>
+</html>
+`))
+
+// handleAckLink shows a confirmation page for an acknowledgement link and acknowledges the alert when it's
+// confirmed, so link scanners in mail filters don't acknowledge alerts.
+func (api *apiServer) handleAckLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+
+	data := struct {
+		Token        string
+		Rule         string
+		Instance     string
+		Severity     string
+		Acknowledged bool
+		Error        string
+	}{Token: r.FormValue("token")}
+
+	status := http.StatusOK
+	t, err := api.alertServer.ackLinks.Verify(data.Token, time.Now())
+	if err != nil {
+		status = http.StatusBadRequest
+		data.Error = err.Error()
+	} else {
+		parts := strings.SplitN(t.Alert, "/", 2)
+		data.Instance = parts[0]
+		if len(parts) == 2 {
+			data.Rule = parts[1]
+		}
+		data.Severity = t.Severity
+	}
+
+	if err == nil && r.Method == http.MethodPost {
+		ack, err := api.alertServer.acknowledgements.Add(acknowledgement{Alert: t.Alert, Severity: t.Severity, AcknowledgedBy: "acknowledgement link"}, time.Now())
+		if err != nil {
+			log.Println("Error acknowledging alert: ", err)
+			status = http.StatusInternalServerError
+			data.Error = "Unable to acknowledge the alert, please try again later"
+		} else {
+			log.Printf("Alert %s acknowledged by %s\n", ack.Alert, ack.AcknowledgedBy)
+			data.Acknowledged = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := ackPage.Execute(w, data); err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
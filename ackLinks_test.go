@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAckLinks(t *testing.T) {
+	links := NewAckLinks("secret", "https://alerts.example.com/", time.Hour)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	link, err := links.URL(testMessage().Labels, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host != "alerts.example.com" || u.Path != "/acknowledge" {
+		t.Fatalf("unexpected link %s", link)
+	}
+	token := u.Query().Get("token")
+	parts := strings.Split(token, ".")
+	otherAlert := base64.RawURLEncoding.EncodeToString([]byte(`{"a":"guid-2/disk","s":"critical","e":1714568400}`))
+
+	for _, test := range []struct {
+		name    string
+		links   *ackLinks
+		token   string
+		now     time.Time
+		wantErr string
+	}{
+		{"valid", links, token, now, ""},
+		{"just before expiry", links, token, now.Add(time.Hour), ""},
+		{"expired", links, token, now.Add(time.Hour + time.Second), "This link has expired"},
+		{"tampered payload", links, otherAlert + "." + parts[1], now, "Invalid token"},
+		{"tampered signature", links, parts[0] + "." + parts[0], now, "Invalid token"},
+		{"other secret", NewAckLinks("other", "", time.Hour), token, now, "Invalid token"},
+		{"no signature", links, parts[0], now, "Invalid token"},
+		{"not base64", links, "!!!." + parts[1], now, "Invalid token"},
+		{"empty", links, "", now, "Invalid token"},
+	} {
+		ack, err := test.links.Verify(test.token, test.now)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if ack.Alert != "guid-1/disk" || ack.Severity != "critical" {
+				t.Errorf("%s: unexpected token %+v", test.name, ack)
+			}
+		} else if err == nil || err.Error() != test.wantErr {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.wantErr)
+		}
+	}
+}
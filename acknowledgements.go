@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// acknowledgement marks an alert as being worked on. It covers the alert until it resolves, and only while it has
+// the acknowledged severity when one is set.
+type acknowledgement struct {
+	Alert          string    `json:"alert"` //the alert key: <service instance guid>/<rule name>
+	Severity       string    `json:"severity,omitempty"`
+	AcknowledgedBy string    `json:"acknowledged_by"`
+	AcknowledgedAt time.Time `json:"acknowledged_at"`
+}
+
+// Covers reports whether the acknowledgement applies to the current firing period of the alert.
+func (ack acknowledgement) Covers(state alertState) bool {
+	return ack.Alert == state.Labels.Key() &&
+		!ack.AcknowledgedAt.Before(state.FiringSince) &&
+		(ack.Severity == "" || ack.Severity == state.Labels.Severity)
+}
+
+func (ack acknowledgement) Validate() error {
+	if ack.Alert == "" {
+		return fmt.Errorf("alert is required")
+	}
+	if ack.AcknowledgedBy == "" {
+		return fmt.Errorf("acknowledged_by is required")
+	}
+
+	return nil
+}
+
+// acknowledgementStore keeps acknowledgements in the state store, shared by all instances like silences.
+type acknowledgementStore struct {
+	mu    sync.Mutex
+	store stateStore
+}
+
+const acknowledgementsStateName = "acknowledgements"
+
+func NewAcknowledgementStore(store stateStore) *acknowledgementStore {
+	return &acknowledgementStore{store: store}
+}
+
+// List returns the latest acknowledgement per alert.
+func (s *acknowledgementStore) List() (map[string]acknowledgement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+func (s *acknowledgementStore) Add(ack acknowledgement, now time.Time) (acknowledgement, error) {
+	ack.AcknowledgedAt = now
+	if err := ack.Validate(); err != nil {
+		return acknowledgement{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.store.Lock(acknowledgementsStateName)
+	if err != nil {
+		return acknowledgement{}, err
+	}
+	defer unlock()
+
+	acks, err := s.load()
+	if err != nil {
+		return acknowledgement{}, err
+	}
+
+	//alerts acknowledged more than a month ago have most likely resolved since
+	for key, existing := range acks {
+		if now.Sub(existing.AcknowledgedAt) > 30*24*time.Hour {
+			delete(acks, key)
+		}
+	}
+	acks[ack.Alert] = ack
+
+	return ack, s.store.Save(acknowledgementsStateName, acks)
+}
+
+func (s *acknowledgementStore) load() (map[string]acknowledgement, error) {
+	acks := make(map[string]acknowledgement)
+	if err := s.store.Load(acknowledgementsStateName, &acks); err != nil {
+		return nil, fmt.Errorf("Unable to load acknowledgements: %v", err)
+	}
+
+	return acks, nil
+}
@@ -5,34 +5,138 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/cloudfoundry-community/go-cfclient"
 	"github.com/prometheus/common/model"
 )
 
 type alertRule struct {
-	Name           string `json:"name"`
-	Promq          string `json:"prometheus_query"`
-	Treshold       string `json:"treshold"`
-	NotifyInterval string `json:"notification_interval"`
-	Above          bool   `json:"above"`
-	Subject        string `json:"subject"`
-	Message        string `json:"message"`
+	Id              string   `json:"id"`
+	Name            string   `json:"name"`
+	Promq           string   `json:"prometheus_query"`
+	Treshold        string   `json:"treshold"`
+	NotifyInterval  string   `json:"notification_interval"`
+	Above           bool     `json:"above"`
+	Subject         string   `json:"subject"`
+	Message         string   `json:"message"`
+	ResolvedSubject string   `json:"resolved_subject"`
+	ResolvedMessage string   `json:"resolved_message"`
+	Severity        string   `json:"severity"`
+	QuietHours      []string `json:"quiet_hours"`
+	Targets         []string `json:"targets"`
+
+	NotifySharedSpaces   bool `json:"notify_shared_spaces"`
+	NotifyBoundAppSpaces bool `json:"notify_bound_app_spaces"`
+
+	Escalations []escalationStep `json:"escalations"`
+	Repeat      *repeatSchedule  `json:"repeat"`
+	Format      string           `json:"format"`
+	Chart       *chartConfig     `json:"chart"`
+
+	Translations map[string]ruleTranslation `json:"translations"` //keyed by language
+
+	targets        []target
+	notifyInterval time.Duration
+}
+
+// ruleTranslation replaces the templates of a rule for tenants using another language. Templates which are left
+// empty fall back to those of the rule.
+type ruleTranslation struct {
+	Subject         string `json:"subject"`
+	Message         string `json:"message"`
+	ResolvedSubject string `json:"resolved_subject"`
+	ResolvedMessage string `json:"resolved_message"`
 }
 
+const defaultResolvedMessage = "Alert \"{{.AlertName}}\" in environment {{.EnvironmentName}} for your service instance {{.InstanceName}} in Cloudfoundry org/space: {{.OrgName}}/{{.SpaceName}} is resolved."
+
 type alertRuleSet []alertRule
 
 type alertRules map[string]alertRuleSet
 
-func (rs alertRuleSet) Process(a *alertServer, serviceInstance cfclient.V3ServiceInstance) {
+// Key returns the identifier used to refer to the rule from annotations. It defaults to the lowercased rule name with spaces replaced by dashes.
+func (rule *alertRule) Key() string {
+	if rule.Id != "" {
+		return rule.Id
+	}
+
+	return strings.ReplaceAll(strings.ToLower(rule.Name), " ", "-")
+}
+
+// templatesFor returns the rule with the templates for language in Subject and Message, the resolved ones when
+// resolved is set.
+func (rule alertRule) templatesFor(language string, resolved bool) alertRule {
+	if t, ok := rule.Translations[strings.ToLower(language)]; ok {
+		for _, field := range []struct {
+			dest  *string
+			value string
+		}{
+			{&rule.Subject, t.Subject},
+			{&rule.Message, t.Message},
+			{&rule.ResolvedSubject, t.ResolvedSubject},
+			{&rule.ResolvedMessage, t.ResolvedMessage},
+		} {
+			if field.value != "" {
+				*field.dest = field.value
+			}
+		}
+	}
+
+	if !resolved {
+		return rule
+	}
+
+	if rule.ResolvedSubject != "" {
+		rule.Subject = rule.ResolvedSubject
+	} else {
+		rule.Subject = "Resolved: " + rule.Subject
+	}
+
+	if rule.ResolvedMessage != "" {
+		rule.Message = rule.ResolvedMessage
+	} else {
+		rule.Message = defaultResolvedMessage
+	}
+
+	return rule
+}
+
+func (rule *alertRule) SeverityOrDefault() string {
+	if rule.Severity != "" {
+		return rule.Severity
+	}
+
+	return "warning"
+}
+
+// firingAlert is a rule that is firing for a service instance during the current scan.
+type firingAlert struct {
+	rule  alertRule
+	state alertState
+	value model.SampleValue
+}
+
+func (rs alertRuleSet) Process(a *alertServer, info instanceInfo) {
+	serviceInstance := info.Instance
+	now := time.Now()
+
+	//evaluate all rules first so alerts can inhibit each other
+	var firing []firingAlert
 	for _, rule := range rs {
+		if treshold, ok := info.Overrides.Tresholds[rule.Key()]; ok {
+			rule.Treshold = treshold
+		}
+
 		vres, err := a.GetMetric(rule.Promq, serviceInstance.Guid)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
+		var firingSample *model.Sample
 		for _, sample := range vres {
 			exceeded, err := rule.TresholdExceeded(*sample)
 			if err != nil {
@@ -41,18 +145,271 @@ func (rs alertRuleSet) Process(a *alertServer, serviceInstance cfclient.V3Servic
 			}
 
 			if exceeded {
-				msg, err := rule.GenerateMessageForSpace(*a.cfClient, serviceInstance, sample.Value, a.environment)
-				if err != nil {
-					log.Println("Error generating notification: ", err)
-				}
+				firingSample = sample
+				break //Instances might exist of multiple VMs / containers and return multiple metrics which exceed the treshold. We only have to alert once so we break the loop after one alert is generated.
+			}
+		}
+
+		labels := info.Labels(rule)
+		if firingSample == nil {
+			state, resolved := a.alerts.Evaluate(labels, false, "", now)
+			if state.Flapping {
+				a.notifyFlapping(info, rule, state, now)
+			} else if resolved && (state.Notified() || state.stabilized) {
+				a.notifyResolved(info, rule, state, now)
+			}
+			continue
+		}
 
-				err = a.notificationSerivceClient.Send(msg)
-				if err != nil {
-					log.Println("Notification not sent: ", err)
-				}
+		//flapping alerts are added too, as they still inhibit other alerts
+		state, _ := a.alerts.Evaluate(labels, true, firingSample.Value.String(), now)
+		firing = append(firing, firingAlert{
+			rule:  rule,
+			state: state,
+			value: firingSample.Value,
+		})
+	}
 
-				break //Instances might exist of multiple VMs / containers and return multiple metrics which exceed the treshold. We only have to alert once so we break the loop after one alert is generated.
+	for _, alert := range firing {
+		if alert.state.Flapping {
+			a.notifyFlapping(info, alert.rule, alert.state, now)
+			continue
+		}
+
+		if source := inhibitingAlert(a.inhibitRules, alert, firing); source != nil {
+			log.Printf("Alert %s for service %s is inhibited by %s\n", alert.rule.Name, serviceInstance.Guid, source.rule.Name)
+			alert.state.InhibitedBy = source.rule.Name
+			a.alerts.Put(alert.state)
+			continue
+		}
+		alert.state.InhibitedBy = ""
+
+		a.notify(info, alert, now)
+	}
+}
+
+// Resolve resolves the firing alerts of a service instance for which alerting is disabled or snoozed, so they don't
+// stay open in the notifiers. Notified alerts get a resolved notification.
+func (rs alertRuleSet) Resolve(a *alertServer, info instanceInfo) {
+	now := time.Now()
+	for _, rule := range rs {
+		state, resolved := a.alerts.Evaluate(info.Labels(rule), false, "", now)
+		if resolved && state.Notified() {
+			a.notifyResolved(info, rule, state, now)
+		}
+	}
+}
+
+func (a *alertServer) notify(info instanceInfo, alert firingAlert, now time.Time) {
+	rule := alert.rule
+	state := alert.state
+	serviceInstance := info.Instance
+
+	if sil := matchSilence(a.activeSilences, state.Labels, now); sil != nil {
+		log.Printf("Alert %s for service %s is silenced by %s\n", rule.Name, serviceInstance.Guid, sil.Id)
+		state.SilencedBy = sil.Id
+		a.alerts.Put(state)
+		return
+	}
+	state.SilencedBy = ""
+
+	if window := activeQuietHours(a.quietHours, rule, state.Labels, now); window != nil {
+		if window.Action == quietHoursDefer {
+			log.Printf("Alert %s for service %s is deferred by quiet hours %s\n", rule.Name, serviceInstance.Guid, window.Name)
+			state.Deferred = true
+		} else {
+			log.Printf("Alert %s for service %s is suppressed by quiet hours %s\n", rule.Name, serviceInstance.Guid, window.Name)
+			//alerts which were already firing before the quiet hours started get their reminders again afterwards
+			state.Suppressed = state.Suppressed || window.Active(state.FiringSince)
+		}
+		a.alerts.Put(state)
+		return
+	}
+
+	if state.Suppressed {
+		//alerts which started during suppressing quiet hours stay quiet until they clear
+		a.alerts.Put(state)
+		return
+	}
+
+	if state.Deferred {
+		log.Printf("Delivering deferred alert %s for service %s\n", rule.Name, serviceInstance.Guid)
+		state.Deferred = false
+	}
+
+	localized := rule.templatesFor(info.Overrides.Language, false)
+	a.escalate(info, localized, &state, formatMetricValue(alert.value), now)
+
+	if ack, ok := a.activeAcknowledgements[state.Labels.Key()]; ok && ack.Covers(state) {
+		//acknowledged alerts are not repeated until they resolve
+		state.AcknowledgedBy = ack.AcknowledgedBy
+		a.alerts.Put(state)
+		a.refresh(info, localized, state, alert.value)
+		return
+	}
+
+	//with grouping, alerts are added to their group on every scan and the group decides when to send
+	due := rule.notificationDue(state, now)
+	if !a.groups.Enabled() && !due {
+		a.alerts.Put(state)
+		a.refresh(info, localized, state, alert.value)
+		return
+	}
+	reminder := state.Notified()
+
+	appNames := a.boundAppNames(info, rule)
+	msg, err := localized.generateMessage(*a.cfClient, serviceInstance, a.environment, messageTemplateData{
+		Treshold:    rule.Treshold,
+		MetricValue: formatMetricValue(alert.value),
+		BoundApps:   appNames[info.Space.GUID],
+	})
+	if err != nil {
+		log.Println("Error generating notification: ", err)
+		a.alerts.Put(state)
+		return
+	}
+	msg.Status = statusFiring
+	msg.Labels = state.Labels
+	msg.StartsAt = state.FiringSince
+	if rule.Repeat != nil && reminder {
+		//every reminder is a new notification for cfNotificationService
+		msg.Id = fmt.Sprintf("%s-reminder-%d", strings.TrimSpace(msg.Id), state.Reminders+1)
+	}
+
+	if !a.groups.Enabled() {
+		//group notifications have no charts
+		msg.Chart = a.chart(rule, serviceInstance.Guid, now)
+	}
+
+	consumerMsgs := a.consumerMessages(info, rule, false, msg, formatMetricValue(alert.value), appNames)
+	a.addAckLink(&msg, state.Labels, now)
+	for i := range consumerMsgs {
+		a.addAckLink(&consumerMsgs[i], state.Labels, now)
+	}
+
+	if a.groups.Enabled() {
+		a.notifyGrouped(info, localized, state, alert.value, append([]NotificationMessage{msg}, consumerMsgs...), due, now)
+		return
+	}
+
+	err = a.send(msg)
+	if err != nil {
+		log.Println("Notification not sent: ", err)
+	}
+	if notified(err) {
+		//rate limited alerts are in the suppressed summary and alerts sent before won't be sent again by
+		//cfNotificationService, neither is notified again on the next scan
+		if reminder {
+			state.Reminders++
+		}
+		state.LastNotified = now
+	}
+	a.alerts.Put(state)
+
+	for _, m := range consumerMsgs {
+		if err := a.send(m); err != nil {
+			log.Println("Notification to consumer space not sent: ", err)
+		}
+	}
+}
+
+// notifyGrouped routes firing alerts and adds them to their group on every scan, the group intervals decide when
+// they are sent. Sinks which track single alerts, like PagerDuty, get the alerts themselves when a notification is due
+// and refreshes in between.
+func (a *alertServer) notifyGrouped(info instanceInfo, rule alertRule, state alertState, value model.SampleValue, msgs []NotificationMessage, due bool, now time.Time) {
+	var perAlert []delivery
+	for _, m := range msgs {
+		for _, d := range routeNotification(a.routes, m) {
+			alertSinks, groupSinks := a.outbox.sinks.splitPerAlert(d.sinks)
+			if len(groupSinks) > 0 {
+				a.groups.Add(delivery{msg: d.msg, sinks: groupSinks}, now)
 			}
+			if len(alertSinks) > 0 {
+				perAlert = append(perAlert, delivery{msg: d.msg, sinks: alertSinks})
+			}
+		}
+	}
+
+	if !due {
+		a.alerts.Put(state)
+		a.refresh(info, rule, state, value)
+		return
+	}
+
+	for _, d := range perAlert {
+		if err := a.sendRouted(d); err != nil {
+			log.Println("Notification not sent: ", err)
+		}
+	}
+	if state.Notified() {
+		state.Reminders++
+	}
+	state.LastNotified = now
+	a.alerts.Put(state)
+}
+
+// refresh sends a firing alert for which no notification is due to the notifiers which need firing alerts on every
+// check, like Alertmanager which resolves alerts that aren't posted again within its resolve_timeout.
+func (a *alertServer) refresh(info instanceInfo, rule alertRule, state alertState, value model.SampleValue) {
+	if !a.outbox.sinks.refreshing() {
+		return
+	}
+
+	subject, message, err := rule.render(a.templateData(info, rule, formatMetricValue(value)))
+	if err != nil {
+		log.Println("Error generating notification: ", err)
+		return
+	}
+
+	msg := NotificationMessage{
+		Id:        fmt.Sprintf("%s-%s\n", info.Instance.Guid, rule.Name),
+		Subject:   subject,
+		Message:   message,
+		ExpiresIn: rule.NotifyInterval,
+		Targets:   rule.targets,
+		Format:    rule.Format,
+		Target: NotificationMessageTarget{
+			Type:        "space",
+			Environment: a.environment,
+			Id:          info.Space.GUID,
+		},
+		Status:   statusFiring,
+		Labels:   state.Labels,
+		StartsAt: state.FiringSince,
+		Refresh:  true,
+	}
+	if err := a.deliver(msg); err != nil {
+		log.Println("Notification not sent: ", err)
+	}
+}
+
+// notifyResolved tells notifiers which want to know about resolved alerts that a notified alert stopped firing.
+func (a *alertServer) notifyResolved(info instanceInfo, rule alertRule, state alertState, now time.Time) {
+	localized := rule.templatesFor(info.Overrides.Language, true)
+	appNames := a.boundAppNames(info, rule)
+	msg, err := localized.generateMessage(*a.cfClient, info.Instance, a.environment, messageTemplateData{
+		Treshold:    rule.Treshold,
+		MetricValue: formatMetricValue(0),
+		BoundApps:   appNames[info.Space.GUID],
+	})
+	if err != nil {
+		log.Println("Error generating notification: ", err)
+		return
+	}
+	msg.Id = fmt.Sprintf("%s-%s-resolved", info.Instance.Guid, rule.Name)
+	msg.Status = statusResolved
+	msg.Labels = state.Labels
+	msg.StartsAt = state.FiringSince
+	msg.EndsAt = now
+
+	log.Printf("Alert %s for service %s is resolved\n", rule.Name, info.Instance.Guid)
+	if err := a.send(msg); err != nil {
+		log.Println("Notification not sent: ", err)
+	}
+
+	for _, m := range a.consumerMessages(info, rule, true, msg, formatMetricValue(0), appNames) {
+		if err := a.send(m); err != nil {
+			log.Println("Notification to consumer space not sent: ", err)
 		}
 	}
 }
@@ -78,7 +435,57 @@ func (rule *alertRule) TresholdExceeded(sample model.Sample) (bool, error) {
 	return false, nil
 }
 
+// messageTemplateData is exposed to the subject and message templates.
+type messageTemplateData struct {
+	AlertName       string
+	InstanceId      string
+	InstanceName    string
+	EnvironmentName string
+	SpaceName       string
+	OrgName         string
+	Treshold        string
+	MetricValue     string
+	Labels          map[string]string //only set for alerts received from Alertmanager
+	Recipient       string            //owner, or consumer for spaces the service instance is shared with
+	RecipientSpace  string            //the space that is notified
+	RecipientOrg    string            //the org of the space that is notified
+	BoundApps       []string          //the apps in the notified space bound to the service instance, only set with notify_bound_app_spaces
+	FiringSince     time.Time         //only set for escalations
+	EscalationLevel int               //only set for escalations, starting at 1
+	Annotations     map[string]string //only set for alerts received from Alertmanager
+}
+
+// escapedMarkdown returns the template data with the values tenants control escaped for Markdown.
+func (d messageTemplateData) escapedMarkdown() messageTemplateData {
+	d.InstanceName = escapeMarkdown(d.InstanceName)
+	d.SpaceName = escapeMarkdown(d.SpaceName)
+	d.OrgName = escapeMarkdown(d.OrgName)
+	d.RecipientSpace = escapeMarkdown(d.RecipientSpace)
+	d.RecipientOrg = escapeMarkdown(d.RecipientOrg)
+
+	apps := make([]string, len(d.BoundApps))
+	for i, app := range d.BoundApps {
+		apps[i] = escapeMarkdown(app)
+	}
+	d.BoundApps = apps
+
+	return d
+}
+
 func (rule *alertRule) GenerateMessageForSpace(client cfclient.Client, serviceInstance cfclient.V3ServiceInstance, sampleValue model.SampleValue, environment string) (NotificationMessage, error) {
+	return rule.generateMessage(client, serviceInstance, environment, messageTemplateData{
+		Treshold:    rule.Treshold,
+		MetricValue: formatMetricValue(sampleValue),
+	})
+}
+
+func formatMetricValue(sampleValue model.SampleValue) string {
+	floatMetricValue, _ := strconv.ParseFloat(sampleValue.String(), 32)
+	return fmt.Sprintf("%.2f", floatMetricValue)
+}
+
+// generateMessage completes templData with the alert, instance, space and org and renders the rule's templates.
+func (rule *alertRule) generateMessage(client cfclient.Client, serviceInstance cfclient.V3ServiceInstance, environment string, templData messageTemplateData) (NotificationMessage, error) {
 	space, err := client.GetSpaceByGuid(serviceInstance.Relationships["space"].Data.GUID)
 	if err != nil {
 		return NotificationMessage{}, err
@@ -89,47 +496,30 @@ func (rule *alertRule) GenerateMessageForSpace(client cfclient.Client, serviceIn
 		return NotificationMessage{}, err
 	}
 
-	floatMetricValue, _ := strconv.ParseFloat(sampleValue.String(), 32)
-
-	templData := struct {
-		AlertName       string
-		InstanceId      string
-		InstanceName    string
-		EnvironmentName string
-		SpaceName       string
-		OrgName         string
-		Treshold        string
-		MetricValue     string
-	}{
-		AlertName:       rule.Name,
-		InstanceId:      serviceInstance.Guid,
-		InstanceName:    serviceInstance.Name,
-		EnvironmentName: environment,
-		SpaceName:       space.Name,
-		OrgName:         org.Name,
-		Treshold:        rule.Treshold,
-		MetricValue:     fmt.Sprintf("%.2f", floatMetricValue),
-	}
+	templData.AlertName = rule.Name
+	templData.InstanceId = serviceInstance.Guid
+	templData.InstanceName = serviceInstance.Name
+	templData.EnvironmentName = environment
+	templData.SpaceName = space.Name
+	templData.OrgName = org.Name
+	templData.Recipient = "owner"
+	templData.RecipientSpace = space.Name
+	templData.RecipientOrg = org.Name
 
 	log.Printf("Generating notification for service %s in space: %s(%s)\n", serviceInstance.Name, space.Name, space.Guid)
 
-	var renderedMessage bytes.Buffer
-	msgTmpl, err := template.New("msg").Parse(rule.Message)
-	if err := msgTmpl.Execute(&renderedMessage, templData); err != nil {
-		return NotificationMessage{}, fmt.Errorf("Error rendering message: %v", err)
-	}
-
-	var renderedSubject bytes.Buffer
-	subjTmpl, err := template.New("subject").Parse(rule.Subject)
-	if err := subjTmpl.Execute(&renderedSubject, templData); err != nil {
-		return NotificationMessage{}, fmt.Errorf("Error rendering subject: %v", err)
+	subject, message, err := rule.render(templData)
+	if err != nil {
+		return NotificationMessage{}, err
 	}
 
 	msg := NotificationMessage{
 		Id:        fmt.Sprintf("%s-%s\n", serviceInstance.Guid, rule.Name),
-		Subject:   renderedSubject.String(),
-		Message:   renderedMessage.String(),
+		Subject:   subject,
+		Message:   message,
 		ExpiresIn: rule.NotifyInterval,
+		Targets:   rule.targets,
+		Format:    rule.Format,
 		Target: NotificationMessageTarget{
 			Type:        "space",
 			Environment: environment,
@@ -139,3 +529,50 @@ func (rule *alertRule) GenerateMessageForSpace(client cfclient.Client, serviceIn
 
 	return msg, nil
 }
+
+// templateData returns the template data for rendering the rule's templates for the owning space of a service
+// instance without looking it up again.
+func (a *alertServer) templateData(info instanceInfo, rule alertRule, metricValue string) messageTemplateData {
+	return messageTemplateData{
+		AlertName:       rule.Name,
+		InstanceId:      info.Instance.Guid,
+		InstanceName:    info.Instance.Name,
+		EnvironmentName: a.environment,
+		SpaceName:       info.Space.Name,
+		OrgName:         info.Org.Name,
+		Treshold:        rule.Treshold,
+		MetricValue:     metricValue,
+		Recipient:       "owner",
+		RecipientSpace:  info.Space.Name,
+		RecipientOrg:    info.Org.Name,
+	}
+}
+
+// render renders the subject and message templates of the rule. Markdown messages get the names tenants choose
+// escaped, so those can't change the formatting of the message.
+func (rule *alertRule) render(templData messageTemplateData) (string, string, error) {
+	msgData := templData
+	if rule.Format == formatMarkdown {
+		msgData = templData.escapedMarkdown()
+	}
+
+	var renderedMessage bytes.Buffer
+	msgTmpl, err := template.New("msg").Parse(rule.Message)
+	if err != nil {
+		return "", "", fmt.Errorf("Error parsing message template: %v", err)
+	}
+	if err := msgTmpl.Execute(&renderedMessage, msgData); err != nil {
+		return "", "", fmt.Errorf("Error rendering message: %v", err)
+	}
+
+	var renderedSubject bytes.Buffer
+	subjTmpl, err := template.New("subject").Parse(rule.Subject)
+	if err != nil {
+		return "", "", fmt.Errorf("Error parsing subject template: %v", err)
+	}
+	if err := subjTmpl.Execute(&renderedSubject, templData); err != nil {
+		return "", "", fmt.Errorf("Error rendering subject: %v", err)
+	}
+
+	return renderedSubject.String(), renderedMessage.String(), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuleTemplatesForLanguage(t *testing.T) {
+	rule := alertRule{
+		Subject:         "Disk almost full",
+		Message:         "Disk of {{.InstanceName}} is full",
+		ResolvedMessage: "Disk of {{.InstanceName}} is fine again",
+		Translations: map[string]ruleTranslation{
+			"nl": {Subject: "Schijf bijna vol", Message: "Schijf van {{.InstanceName}} is vol", ResolvedSubject: "Opgelost: schijf"},
+			"de": {Subject: "Festplatte fast voll"},
+		},
+	}
+
+	for _, test := range []struct {
+		name        string
+		language    string
+		resolved    bool
+		wantSubject string
+		wantMessage string
+	}{
+		{"no language", "", false, "Disk almost full", "Disk of {{.InstanceName}} is full"},
+		{"unknown language", "fr", false, "Disk almost full", "Disk of {{.InstanceName}} is full"},
+		{"translated", "NL", false, "Schijf bijna vol", "Schijf van {{.InstanceName}} is vol"},
+		{"missing templates fall back to the rule", "de", false, "Festplatte fast voll", "Disk of {{.InstanceName}} is full"},
+		{"resolved", "", true, "Resolved: Disk almost full", "Disk of {{.InstanceName}} is fine again"},
+		{"translated resolved", "nl", true, "Opgelost: schijf", "Disk of {{.InstanceName}} is fine again"},
+		{"default resolved subject of a translated subject", "de", true, "Resolved: Festplatte fast voll", "Disk of {{.InstanceName}} is fine again"},
+	} {
+		got := rule.templatesFor(test.language, test.resolved)
+		if got.Subject != test.wantSubject || got.Message != test.wantMessage {
+			t.Errorf("%s: got %q, %q", test.name, got.Subject, got.Message)
+		}
+	}
+}
+
+func TestTranslationsAreLoadedByLowercaseLanguage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	config := `{"rules": {"redis": [{"name": "memory", "subject": "Memory", "translations": {"NL": {"subject": "Geheugen"}}}]}}`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := rulesConfigLoad(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules.Rules["redis"][0].templatesFor("nl", false).Subject; got != "Geheugen" {
+		t.Errorf("subject = %q", got)
+	}
+}
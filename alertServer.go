@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -13,14 +14,63 @@ import (
 )
 
 type alertServer struct {
-	cfClient                  *cfclient.Client
-	promClient                *PrometheusClient
-	appGuid                   string
-	node                      string
-	nodes                     int
-	alertRules                alertRules
-	environment               string
-	notificationSerivceClient NotificationServiceClient
+	cfClient               *cfclient.Client
+	promClient             *PrometheusClient
+	appGuid                string
+	node                   string
+	nodes                  int32 //read by the API, use nodeCount and setNodes
+	sharedState            bool  //STATE_PATH is set, so it's expected to be shared by all instances
+	store                  stateStore
+	alertRules             alertRules
+	environment            string
+	outbox                 *outbox
+	rateLimiter            *rateLimiter
+	groups                 *notificationGroups
+	alerts                 *alertStates
+	silences               *silenceStore
+	activeSilences         []silence
+	acknowledgements       *acknowledgementStore
+	activeAcknowledgements map[string]acknowledgement
+	ackLinks               *ackLinks
+	quietHours             []quietHours
+	inhibitRules           []inhibitRule
+	routes                 []route
+	flapDetection          flapDetection
+	alertmanagerReceiver   alertmanagerReceiverConfig
+	operationGracePeriod   time.Duration
+}
+
+// instanceInfo is everything we know about a service instance while evaluating its rules.
+type instanceInfo struct {
+	Instance  cfclient.V3ServiceInstance
+	Space     *cfclient.V3Space
+	Org       *cfclient.V3Organization
+	Service   string
+	Plan      string
+	Overrides instanceOverrides
+}
+
+func (i instanceInfo) Labels(rule alertRule) alertLabels {
+	return alertLabels{
+		Org:          i.Org.Name,
+		OrgGuid:      i.Org.GUID,
+		Space:        i.Space.Name,
+		SpaceGuid:    i.Space.GUID,
+		InstanceGuid: i.Instance.Guid,
+		InstanceName: i.Instance.Name,
+		Service:      i.Service,
+		Plan:         i.Plan,
+		Rule:         rule.Name,
+		Severity:     rule.SeverityOrDefault(),
+	}
+}
+
+func (a *alertServer) nodeCount() int {
+	return int(atomic.LoadInt32(&a.nodes))
+}
+
+func (a *alertServer) setNodes(nodes int) {
+	atomic.StoreInt32(&a.nodes, int32(nodes))
 }
 
 func (a *alertServer) Start(checkInterval int64) {
@@ -33,20 +83,21 @@ func (a *alertServer) Start(checkInterval int64) {
 }
 
 func (a *alertServer) scanServices() {
+	scanStart := time.Now()
 	app, _ := a.cfClient.GetAppByGuid(a.appGuid)
-	a.nodes = app.Instances
+	a.setNodes(app.Instances)
 
 	ring := hashring.New(6, nil)
-	for i := a.nodes; i > 0; i-- {
+	for i := app.Instances; i > 0; i-- {
 		ring.Add(fmt.Sprintf("%v", i-1))
 	}
 
-	serviceInstances, err := a.cfClient.ListV3ServiceInstances()
+	serviceInstances, err := listServiceInstances(a.cfClient)
 	if err != nil {
 		log.Printf("Unable to scan services: %s\n", err)
 		return
 	}
-	var filteredServiceInstances []cfclient.V3ServiceInstance
+	var filteredServiceInstances []serviceInstance
 
 	for _, serviceInstance := range serviceInstances {
 		n, _ := ring.Locate(serviceInstance.Guid)
@@ -55,6 +106,18 @@ func (a *alertServer) scanServices() {
 		}
 	}
 
+	metadata := newMetadataCache(a.cfClient)
+
+	a.activeSilences, err = a.silences.List()
+	if err != nil {
+		log.Println(err)
+	}
+
+	a.activeAcknowledgements, err = a.acknowledgements.List()
+	if err != nil {
+		log.Println(err)
+	}
+
 	log.Printf("Processing %v instances\n", len(filteredServiceInstances))
 	for _, serviceInstance := range filteredServiceInstances {
 		if serviceInstance.Relationships["service_plan"].Data.GUID == "" {
@@ -72,24 +135,110 @@ func (a *alertServer) scanServices() {
 		}
 
 		if ruleSet, ok := a.alertRules[service.Label]; ok {
+			if op := serviceInstance.LastOperation; op.InProgress() {
+				log.Printf("Skipping service %v, %s operation in progress\n", serviceInstance.Guid, op.Type)
+				continue
+			} else if time.Since(op.UpdatedAt) < a.operationGracePeriod {
+				log.Printf("Skipping service %v, %s operation finished less than %v ago\n", serviceInstance.Guid, op.Type, a.operationGracePeriod)
+				continue
+			}
+
+			space, err := metadata.Space(serviceInstance.Relationships["space"].Data.GUID)
+			if err != nil {
+				log.Printf("Error getting space for service %v: %v\n", serviceInstance.Guid, err)
+				continue
+			}
+
+			org, err := metadata.Org(space.Relationships["organization"].Data.GUID)
+			if err != nil {
+				log.Printf("Error getting org for service %v: %v\n", serviceInstance.Guid, err)
+				continue
+			}
+
+			info := instanceInfo{
+				Instance:  serviceInstance.V3ServiceInstance,
+				Space:     space,
+				Org:       org,
+				Service:   service.Label,
+				Plan:      servicePlan.Name,
+				Overrides: resolveOverrides(serviceInstance.V3ServiceInstance, space, org),
+			}
+
+			if info.Overrides.Disabled {
+				log.Printf("Alerting disabled by annotation for service %v\n", serviceInstance.Guid)
+				ruleSet.Resolve(a, info)
+				continue
+			}
+
+			if info.Overrides.Snoozed(time.Now()) {
+				log.Printf("Alerting snoozed by annotation for service %v until %v\n", serviceInstance.Guid, info.Overrides.SnoozeUntil.Format(time.RFC3339))
+				ruleSet.Resolve(a, info)
+				continue
+			}
+
 			log.Printf("Checking %v service with guid: %v\n", service.Label, serviceInstance.Guid)
-			ruleSet.Process(a, serviceInstance)
+			ruleSet.Process(a, info)
 		}
 	}
+
+	a.sendGroups(scanStart)
+	a.sendSuppressedSummaries()
+	a.alerts.Save(24*time.Hour, time.Now())
 }
 
-func (a *alertServer) GetMetric(queryTemplate, instanceId string) (model.Vector, error) {
-	var renderedQuery bytes.Buffer
-	templData := struct {
-		InstanceId string
-	}{InstanceId: instanceId}
+// lookupInstanceInfo gathers the instanceInfo of a single service instance outside of a scan.
+func (a *alertServer) lookupInstanceInfo(guid string) (instanceInfo, error) {
+	serviceInstance, err := getServiceInstance(a.cfClient, guid)
+	if err != nil {
+		return instanceInfo{}, err
+	}
 
-	t, err := template.New("pq").Parse(queryTemplate)
-	if err := t.Execute(&renderedQuery, templData); err != nil {
-		return nil, fmt.Errorf("Error rendering prometheus query: %v", err)
+	info := instanceInfo{Instance: serviceInstance.V3ServiceInstance}
+
+	if planGuid := serviceInstance.Relationships["service_plan"].Data.GUID; planGuid != "" {
+		servicePlan, err := a.cfClient.GetServicePlanByGUID(planGuid)
+		if err != nil {
+			return instanceInfo{}, err
+		}
+		service, err := a.cfClient.GetServiceByGuid(servicePlan.ServiceGuid)
+		if err != nil {
+			return instanceInfo{}, err
+		}
+		info.Plan = servicePlan.Name
+		info.Service = service.Label
 	}
 
-	res, err := a.promClient.Query(renderedQuery.String())
+	metadata := newMetadataCache(a.cfClient)
+	if info.Space, err = metadata.Space(serviceInstance.Relationships["space"].Data.GUID); err != nil {
+		return instanceInfo{}, err
+	}
+	if info.Org, err = metadata.Org(info.Space.Relationships["organization"].Data.GUID); err != nil {
+		return instanceInfo{}, err
+	}
+	info.Overrides = resolveOverrides(info.Instance, info.Space, info.Org)
+
+	return info, nil
+}
+
+func (a *alertServer) sendGroups(scanStart time.Time) {
+	if !a.groups.Enabled() {
+		return
+	}
+
+	for _, d := range a.groups.Flush(a.environment, scanStart, time.Now()) {
+		if err := a.sendRouted(d); err != nil {
+			log.Println("Group notification not sent: ", err)
+		}
+	}
+}
+
+func (a *alertServer) GetMetric(queryTemplate, instanceId string) (model.Vector, error) {
+	query, err := renderQuery(queryTemplate, instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.promClient.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying prometheus: %v\n", err.Error())
 	}
@@ -100,3 +249,39 @@ func (a *alertServer) GetMetric(queryTemplate, instanceId string) (model.Vector,
 
 	return res.(model.Vector), nil
 }
+
+// GetMetricRange returns the values of the query for the instance over the last duration.
+func (a *alertServer) GetMetricRange(queryTemplate, instanceId string, duration, step time.Duration, now time.Time) (model.Matrix, error) {
+	query, err := renderQuery(queryTemplate, instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.promClient.QueryRange(query, now.Add(-duration), now, step)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying prometheus: %v", err)
+	}
+
+	if res.Type() != model.ValMatrix {
+		return nil, fmt.Errorf("Prometheus range query did not return a matrix")
+	}
+
+	return res.(model.Matrix), nil
+}
+
+func renderQuery(queryTemplate, instanceId string) (string, error) {
+	var renderedQuery bytes.Buffer
+	templData := struct {
+		InstanceId string
+	}{InstanceId: instanceId}
+
+	t, err := template.New("pq").Parse(queryTemplate)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing prometheus query: %v", err)
+	}
+	if err := t.Execute(&renderedQuery, templData); err != nil {
+		return "", fmt.Errorf("Error rendering prometheus query: %v", err)
+	}
+
+	return renderedQuery.String(), nil
+}
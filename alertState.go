@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// alertLabels identify an alert and are what silences (and other matchers) select on.
+type alertLabels struct {
+	Org          string `json:"org"`
+	OrgGuid      string `json:"org_guid"`
+	Space        string `json:"space"`
+	SpaceGuid    string `json:"space_guid"`
+	InstanceGuid string `json:"service_instance_guid"`
+	InstanceName string `json:"service_instance_name"`
+	Service      string `json:"service"`
+	Plan         string `json:"plan"`
+	Rule         string `json:"rule"`
+	Severity     string `json:"severity"`
+}
+
+func (l alertLabels) Key() string {
+	return l.InstanceGuid + "/" + l.Rule
+}
+
+type alertState struct {
+	Labels         alertLabels `json:"labels"`
+	Firing         bool        `json:"firing"`
+	FiringSince    time.Time   `json:"firing_since,omitempty"`
+	ResolvedAt     time.Time   `json:"resolved_at,omitempty"`
+	LastEvaluated  time.Time   `json:"last_evaluated"`
+	LastNotified   time.Time   `json:"last_notified,omitempty"`
+	MetricValue    string      `json:"metric_value,omitempty"`
+	SilencedBy     string      `json:"silenced_by,omitempty"`
+	InhibitedBy    string      `json:"inhibited_by,omitempty"`
+	Suppressed     bool        `json:"suppressed,omitempty"`
+	Deferred       bool        `json:"deferred,omitempty"`
+	Escalations    int         `json:"escalations,omitempty"` //the number of escalation steps sent
+	AcknowledgedBy string      `json:"acknowledged_by,omitempty"`
+	Reminders      int         `json:"reminders,omitempty"` //the number of reminders sent since the first notification
+
+	Transitions      []time.Time `json:"transitions,omitempty"`
+	Flapping         bool        `json:"flapping,omitempty"`
+	FlappingNotified bool        `json:"flapping_notified,omitempty"`
+
+	stabilized bool //flapping ended during the last evaluation, after a flapping notification was sent
+}
+
+// Notified reports whether a notification was sent since the alert started firing.
+func (s alertState) Notified() bool {
+	return !s.LastNotified.IsZero() && !s.LastNotified.Before(s.FiringSince)
+}
+
+// alertStates tracks the state of every (instance, rule) combination this node evaluates.
+type alertStates struct {
+	mu       sync.Mutex
+	store    stateStore
+	name     string
+	states   map[string]alertState
+	flapping flapDetection
+}
+
+func NewAlertStates(store stateStore, node string, flapping flapDetection) *alertStates {
+	s := &alertStates{
+		store:    store,
+		name:     "alerts-" + node,
+		states:   make(map[string]alertState),
+		flapping: flapping,
+	}
+
+	if err := store.Load(s.name, &s.states); err != nil {
+		log.Printf("Unable to load alert state, starting empty: %v\n", err)
+	}
+
+	return s
+}
+
+// Evaluate records the outcome of a rule evaluation and returns the updated state and whether the alert just resolved.
+func (s *alertStates) Evaluate(labels alertLabels, firing bool, metricValue string, now time.Time) (alertState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[labels.Key()]
+	if !ok {
+		state = alertState{}
+	}
+
+	s.flapping.update(&state, ok && firing != state.Firing, now)
+
+	//an alert which stops flapping while not firing resolves the flapping notification
+	resolved := (state.Firing || state.stabilized) && !firing
+
+	if firing && !state.Firing {
+		state.FiringSince = now
+		state.ResolvedAt = time.Time{}
+		state.Escalations = 0
+		state.AcknowledgedBy = ""
+		state.Reminders = 0
+	}
+	if resolved {
+		state.ResolvedAt = now
+	}
+	if !firing {
+		state.SilencedBy = ""
+		state.InhibitedBy = ""
+		state.Suppressed = false
+		state.Deferred = false
+	}
+
+	state.Labels = labels
+	state.Firing = firing
+	state.LastEvaluated = now
+	state.MetricValue = metricValue
+
+	s.states[labels.Key()] = state
+	return state, resolved
+}
+
+func (s *alertStates) Put(state alertState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[state.Labels.Key()] = state
+}
+
+func (s *alertStates) List() []alertState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]alertState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+
+	return states
+}
+
+// Save drops states that haven't been evaluated for a while (deleted instances, instances moved to another node) and persists the rest.
+func (s *alertStates) Save(maxAge time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, state := range s.states {
+		if now.Sub(state.LastEvaluated) > maxAge {
+			delete(s.states, key)
+		}
+	}
+
+	if err := s.store.Save(s.name, s.states); err != nil {
+		log.Printf("Unable to save alert state: %v\n", err)
+	}
+}
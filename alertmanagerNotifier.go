@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type alertmanagerNotifier struct {
+	url        string
+	username   string
+	password   string
+	httpClient http.Client
+}
+
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+func NewAlertmanagerNotifier(config notifierConfig) (*alertmanagerNotifier, error) {
+	if err := requireFields(map[string]string{"url": config.URL}); err != nil {
+		return nil, err
+	}
+
+	return &alertmanagerNotifier{
+		url:        strings.TrimSuffix(config.URL, "/"),
+		username:   config.Username,
+		password:   config.Password,
+		httpClient: newNotifierHttpClient(),
+	}, nil
+}
+
+// Send posts the alert to the Alertmanager v2 API. Alertmanager resolves alerts which are not sent again within its
+// resolve_timeout, so CHECK_INTERVAL should be shorter than that.
+func (n *alertmanagerNotifier) Send(msg NotificationMessage) error {
+	var headers map[string]string
+	if n.username != "" {
+		headers = map[string]string{"Authorization": basicAuth(n.username, n.password)}
+	}
+
+	alert := alertmanagerAlert{
+		Labels: map[string]string{
+			"alertname":             msg.Labels.Rule,
+			"environment":           msg.Target.Environment,
+			"org":                   msg.Labels.Org,
+			"space":                 msg.Labels.Space,
+			"service_instance_guid": msg.Labels.InstanceGuid,
+			"service_instance_name": msg.Labels.InstanceName,
+			"service":               msg.Labels.Service,
+			"plan":                  msg.Labels.Plan,
+			"rule":                  msg.Labels.Rule,
+			"severity":              msg.Labels.Severity,
+		},
+		Annotations: map[string]string{
+			"summary":     msg.Subject,
+			"description": msg.PlainMessage(),
+		},
+		StartsAt: msg.StartsAt,
+	}
+
+	//a resolved alert is an alert which ended, firing alerts get an endsAt from Alertmanager's resolve_timeout
+	if msg.Status == statusResolved {
+		alert.EndsAt = &msg.EndsAt
+	}
+
+	return postJSON(n.httpClient, n.url+"/api/v2/alerts", headers, []alertmanagerAlert{alert})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAlertmanagerEndsAt(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, err := NewAlertmanagerNotifier(notifierConfig{URL: server.URL + "/", Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firing := testMessage()
+	resolved := testMessage()
+	resolved.Status = statusResolved
+	resolved.EndsAt = time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name       string
+		msg        NotificationMessage
+		wantEndsAt bool
+	}{
+		{"firing", firing, false},
+		{"resolved", resolved, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := n.Send(test.msg); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := server.requests()
+			req := requests[len(requests)-1]
+			if req.path != "/api/v2/alerts" {
+				t.Errorf("posted to %s, want /api/v2/alerts", req.path)
+			}
+			if req.header.Get("Authorization") != basicAuth("user", "pass") {
+				t.Errorf("missing basic auth")
+			}
+
+			var alerts []map[string]interface{}
+			if err := json.Unmarshal(req.body, &alerts); err != nil {
+				t.Fatal(err)
+			}
+			if len(alerts) != 1 {
+				t.Fatalf("got %d alerts, want 1", len(alerts))
+			}
+
+			endsAt, ok := alerts[0]["endsAt"]
+			if ok != test.wantEndsAt {
+				t.Fatalf("endsAt = %v, want set: %v", endsAt, test.wantEndsAt)
+			}
+			if ok && endsAt != "2024-05-01T13:00:00Z" {
+				t.Errorf("endsAt = %v, want 2024-05-01T13:00:00Z", endsAt)
+			}
+			if alerts[0]["startsAt"] != "2024-05-01T12:00:00Z" {
+				t.Errorf("startsAt = %v, want 2024-05-01T12:00:00Z", alerts[0]["startsAt"])
+			}
+		})
+	}
+}
+
+func TestAlertmanagerMessageIsPlainText(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, _ := NewAlertmanagerNotifier(notifierConfig{URL: server.URL})
+
+	msg := testMessage()
+	msg.Format = formatMarkdown
+	if err := n.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var alerts []alertmanagerAlert
+	if err := json.Unmarshal(server.requests()[0].body, &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if got := alerts[0].Annotations["description"]; got != "Disk of my_db is 90% full" {
+		t.Errorf("description = %q", got)
+	}
+	if got := alerts[0].Labels["service_instance_guid"]; got != "guid-1" {
+		t.Errorf("service_instance_guid = %q", got)
+	}
+}
+
+func TestOnlyAlertmanagerWantsRefreshes(t *testing.T) {
+	sinks, err := NewNotifiers([]notifierConfig{
+		{Name: "am", Type: "alertmanager", URL: "http://localhost"},
+		{Name: "slack", Type: "slack", URL: "http://localhost"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := testMessage()
+	refresh.Refresh = true
+	if !sinks[0].wants(refresh) || sinks[1].wants(refresh) {
+		t.Errorf("refresh should only go to alertmanager")
+	}
+	if !sinks[0].wants(testMessage()) || !sinks[1].wants(testMessage()) {
+		t.Errorf("notifications should go to all sinks")
+	}
+}
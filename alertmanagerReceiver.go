@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReceivedSubject = "({{.EnvironmentName}}) Alert {{.AlertName}} for service {{.InstanceName}} in cloudfoundry org: {{.OrgName}}/space: {{.SpaceName}}"
+	defaultReceivedMessage = "{{with .Annotations.description}}{{.}}{{else}}{{.Annotations.summary}}{{end}}"
+)
+
+var guidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// alertmanagerReceiverConfig configures how alerts received from Alertmanager are mapped to service instances and
+// rendered. The guid of the service instance is the first guid found in the value of InstanceLabel, so this also
+// works for labels like bosh_deployment=service-instance_<guid>.
+type alertmanagerReceiverConfig struct {
+	InstanceLabel  string `json:"instance_label"`
+	NotifyInterval string `json:"notification_interval"`
+	Subject        string `json:"subject"`
+	Message        string `json:"message"`
+}
+
+func (c *alertmanagerReceiverConfig) parse() error {
+	if c.NotifyInterval != "" {
+		if _, err := time.ParseDuration(c.NotifyInterval); err != nil {
+			return fmt.Errorf("invalid alertmanager_receiver notification_interval: %v", err)
+		}
+	}
+	if c.InstanceLabel == "" {
+		c.InstanceLabel = "service_instance_guid"
+	}
+	if c.Subject == "" {
+		c.Subject = defaultReceivedSubject
+	}
+	if c.Message == "" {
+		c.Message = defaultReceivedMessage
+	}
+
+	return nil
+}
+
+// alertmanagerWebhook is the payload of an Alertmanager webhook_config receiver.
+type alertmanagerWebhook struct {
+	Version  string                     `json:"version"`
+	Status   string                     `json:"status"`
+	Receiver string                     `json:"receiver"`
+	Alerts   []alertmanagerWebhookAlert `json:"alerts"`
+}
+
+type alertmanagerWebhookAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+func (api *apiServer) handleAlertmanagerWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+
+	var webhook alertmanagerWebhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid webhook payload: %v", err))
+		return
+	}
+
+	var failed []string
+	for _, alert := range webhook.Alerts {
+		if err := api.alertServer.receiveAlert(alert); err != nil {
+			log.Printf("Alert %s from Alertmanager not delivered: %v\n", alert.Labels["alertname"], err)
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		//let Alertmanager retry
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("%s", strings.Join(failed, "; ")))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// receiveAlert delivers an alert received from Alertmanager to the space of the service instance it belongs to.
+// Alerts which can't be mapped to an existing service instance are logged and dropped. An error is returned only
+// for transient failures, for which Alertmanager should send the alert again. Deliveries which fail permanently are
+// in the dead letters already, sending them again wouldn't help.
+func (a *alertServer) receiveAlert(alert alertmanagerWebhookAlert) error {
+	cfg := a.alertmanagerReceiver
+
+	guid := guidPattern.FindString(alert.Labels[cfg.InstanceLabel])
+	if guid == "" {
+		log.Printf("Ignoring alert %s from Alertmanager: no service instance guid in label %s\n", alert.Labels["alertname"], cfg.InstanceLabel)
+		return nil
+	}
+
+	info, err := a.lookupInstanceInfo(guid)
+	if errors.Is(err, errServiceInstanceNotFound) {
+		log.Printf("Ignoring alert %s from Alertmanager: %v\n", alert.Labels["alertname"], err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if info.Overrides.Disabled || info.Overrides.Snoozed(now) {
+		log.Printf("Alerting disabled or snoozed by annotation for service %v\n", guid)
+		return nil
+	}
+
+	rule := alertRule{
+		Name:           alert.Labels["alertname"],
+		NotifyInterval: cfg.NotifyInterval,
+		Subject:        cfg.Subject,
+		Message:        cfg.Message,
+		Severity:       alert.Labels["severity"],
+	}
+	labels := info.Labels(rule)
+
+	silences, err := a.silences.List()
+	if err != nil {
+		return err
+	}
+	if sil := matchSilence(silences, labels, now); sil != nil {
+		log.Printf("Alert %s for service %s is silenced by %s\n", rule.Name, guid, sil.Id)
+		return nil
+	}
+
+	msg, err := rule.generateMessage(*a.cfClient, info.Instance, a.environment, messageTemplateData{
+		Labels:      alert.Labels,
+		Annotations: alert.Annotations,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.Id = fmt.Sprintf("%s-%s", guid, alert.Fingerprint)
+	msg.Status = statusFiring
+	msg.Labels = labels
+	msg.StartsAt = alert.StartsAt
+	if alert.Status == statusResolved {
+		msg.Id += "-resolved"
+		msg.Status = statusResolved
+		msg.EndsAt = alert.EndsAt
+	}
+
+	if err := a.send(msg); err != nil {
+		log.Printf("Alert %s from Alertmanager not delivered: %v\n", rule.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAlertmanagerWebhookRequests(t *testing.T) {
+	cfg := alertmanagerReceiverConfig{}
+	cfg.parse()
+	api := &apiServer{alertServer: &alertServer{alertmanagerReceiver: cfg}}
+
+	for _, test := range []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"not a post", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid payload", http.MethodPost, "{", http.StatusBadRequest},
+		{"no alerts", http.MethodPost, `{"version": "4", "status": "firing", "alerts": []}`, http.StatusOK},
+		//alerts without a service instance are dropped, Alertmanager shouldn't send them again
+		{"no instance guid", http.MethodPost, `{"version": "4", "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "HighLoad", "instance": "10.0.0.1"}}]}`, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		api.handleAlertmanagerWebhook(w, httptest.NewRequest(test.method, "/api/alertmanager", strings.NewReader(test.body)))
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.wantStatus)
+		}
+	}
+}
+
+func TestAlertmanagerInstanceGuid(t *testing.T) {
+	for label, want := range map[string]string{
+		"6f8c1e4a-2b3d-4c5e-8f9a-0b1c2d3e4f5a":                  "6f8c1e4a-2b3d-4c5e-8f9a-0b1c2d3e4f5a",
+		"service-instance_6f8c1e4a-2b3d-4c5e-8f9a-0b1c2d3e4f5a": "6f8c1e4a-2b3d-4c5e-8f9a-0b1c2d3e4f5a",
+		"service-instance_not-a-guid":                           "",
+	} {
+		if got := guidPattern.FindString(label); got != want {
+			t.Errorf("guid in %q = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestReceivedAlertTemplates(t *testing.T) {
+	cfg := alertmanagerReceiverConfig{}
+	cfg.parse()
+	if cfg.InstanceLabel != "service_instance_guid" {
+		t.Errorf("default instance_label = %q", cfg.InstanceLabel)
+	}
+
+	rule := alertRule{Name: "HighLoad", Subject: cfg.Subject, Message: cfg.Message}
+	data := messageTemplateData{AlertName: "HighLoad", InstanceName: "my_db", EnvironmentName: "ota", SpaceName: "space", OrgName: "org"}
+
+	for _, test := range []struct {
+		annotations map[string]string
+		want        string
+	}{
+		{map[string]string{"summary": "Load is high", "description": "Load is 12 on 4 cpus"}, "Load is 12 on 4 cpus"},
+		{map[string]string{"summary": "Load is high"}, "Load is high"},
+	} {
+		data.Annotations = test.annotations
+		subject, message, err := rule.render(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject != "(ota) Alert HighLoad for service my_db in cloudfoundry org: org/space: space" {
+			t.Errorf("subject = %q", subject)
+		}
+		if message != test.want {
+			t.Errorf("message = %q, want %q", message, test.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+const annotationPrefix = "cfservicealert.io/"
+
+// instanceOverrides holds the alerting settings tenants can set through CF metadata annotations on a service instance, its space or its org.
+type instanceOverrides struct {
+	Disabled    bool
+	SnoozeUntil time.Time
+	Tresholds   map[string]string //keyed by rule id
+	Language    string
+}
+
+// Snoozed reports whether alerting for the instance is currently snoozed.
+func (o instanceOverrides) Snoozed(now time.Time) bool {
+	return !o.SnoozeUntil.IsZero() && now.Before(o.SnoozeUntil)
+}
+
+// metadataCache prevents looking up the same space or org for every service instance during a single scan.
+type metadataCache struct {
+	client *cfclient.Client
+	spaces map[string]*cfclient.V3Space
+	orgs   map[string]*cfclient.V3Organization
+}
+
+func newMetadataCache(client *cfclient.Client) *metadataCache {
+	return &metadataCache{
+		client: client,
+		spaces: make(map[string]*cfclient.V3Space),
+		orgs:   make(map[string]*cfclient.V3Organization),
+	}
+}
+
+func (c *metadataCache) Space(guid string) (*cfclient.V3Space, error) {
+	if space, ok := c.spaces[guid]; ok {
+		return space, nil
+	}
+
+	space, err := c.client.GetV3SpaceByGUID(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	c.spaces[guid] = space
+	return space, nil
+}
+
+func (c *metadataCache) Org(guid string) (*cfclient.V3Organization, error) {
+	if org, ok := c.orgs[guid]; ok {
+		return org, nil
+	}
+
+	org, err := c.client.GetV3OrganizationByGUID(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	c.orgs[guid] = org
+	return org, nil
+}
+
+// resolveOverrides collects the annotations of the org, space and service instance (in that order, so the most specific one wins) and parses them into instanceOverrides.
+func resolveOverrides(serviceInstance cfclient.V3ServiceInstance, space *cfclient.V3Space, org *cfclient.V3Organization) instanceOverrides {
+	overrides := instanceOverrides{Tresholds: make(map[string]string)}
+
+	instanceAnnotations := make(map[string]string)
+	for k, v := range serviceInstance.Metadata.Annotations {
+		if s, ok := v.(string); ok {
+			instanceAnnotations[k] = s
+		}
+	}
+
+	overrides.apply(org.Metadata.Annotations, "org", org.Name)
+	overrides.apply(space.Metadata.Annotations, "space", space.Name)
+	overrides.apply(instanceAnnotations, "service instance", serviceInstance.Name)
+
+	return overrides
+}
+
+func (o *instanceOverrides) apply(annotations map[string]string, resourceType, resourceName string) {
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, annotationPrefix) {
+			continue
+		}
+
+		if err := o.set(strings.TrimPrefix(key, annotationPrefix), value); err != nil {
+			log.Printf("Invalid annotation %s=%q on %s %s: %v\n", key, value, resourceType, resourceName, err)
+		}
+	}
+}
+
+func (o *instanceOverrides) set(key, value string) error {
+	switch {
+	case key == "disabled":
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("value must be true or false")
+		}
+		o.Disabled = disabled
+	case key == "snooze-until":
+		snoozeUntil, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("value must be an RFC3339 timestamp")
+		}
+		o.SnoozeUntil = snoozeUntil
+	case key == "language":
+		if value == "" {
+			return fmt.Errorf("value must be a language, for example en or nl")
+		}
+		o.Language = strings.ToLower(value)
+	case strings.HasPrefix(key, "threshold."):
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("value must be a whole number")
+		}
+		o.Tresholds[strings.TrimPrefix(key, "threshold.")] = value
+	default:
+		return fmt.Errorf("unknown annotation")
+	}
+
+	return nil
+}
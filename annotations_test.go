@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestResolveOverrides(t *testing.T) {
+	instance := cfclient.V3ServiceInstance{Name: "my_db"}
+	instance.Metadata.Annotations = map[string]interface{}{
+		"cfservicealert.io/threshold.disk": "95",
+		"cfservicealert.io/disabled":       "false",
+		"cfservicealert.io/unknown":        "x",
+		"other.io/disabled":                "true",
+	}
+	space := &cfclient.V3Space{Name: "space"}
+	space.Metadata.Annotations = map[string]string{
+		"cfservicealert.io/threshold.disk":   "90",
+		"cfservicealert.io/threshold.memory": "80",
+		"cfservicealert.io/language":         "NL",
+	}
+	org := &cfclient.V3Organization{Name: "org"}
+	org.Metadata.Annotations = map[string]string{
+		"cfservicealert.io/disabled":       "true",
+		"cfservicealert.io/snooze-until":   "2024-05-01T14:00:00Z",
+		"cfservicealert.io/threshold.cpu":  "not a number",
+		"cfservicealert.io/threshold.load": "3",
+	}
+
+	o := resolveOverrides(instance, space, org)
+
+	if o.Disabled {
+		t.Errorf("the instance annotation should win over the org annotation")
+	}
+	if o.Language != "nl" {
+		t.Errorf("language = %q, want nl", o.Language)
+	}
+	want := map[string]string{"disk": "95", "memory": "80", "load": "3"}
+	if len(o.Tresholds) != len(want) {
+		t.Errorf("tresholds = %v, want %v", o.Tresholds, want)
+	}
+	for rule, treshold := range want {
+		if o.Tresholds[rule] != treshold {
+			t.Errorf("treshold of %s = %q, want %q", rule, o.Tresholds[rule], treshold)
+		}
+	}
+
+	snoozeUntil := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	if !o.Snoozed(snoozeUntil.Add(-time.Minute)) || o.Snoozed(snoozeUntil) {
+		t.Errorf("snoozed until %v, want %v", o.SnoozeUntil, snoozeUntil)
+	}
+}
+
+func TestResolveWhenDisabled(t *testing.T) {
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &alertServer{alerts: NewAlertStates(store, "0", flapDetection{})}
+
+	info := instanceInfo{
+		Instance: cfclient.V3ServiceInstance{Guid: "guid-1", Name: "my_db"},
+		Space:    &cfclient.V3Space{Name: "space"},
+		Org:      &cfclient.V3Organization{Name: "org"},
+	}
+	rules := alertRuleSet{{Name: "disk"}, {Name: "memory"}}
+	a.alerts.Evaluate(info.Labels(rules[0]), true, "95", time.Now())
+
+	rules.Resolve(a, info)
+
+	for _, state := range a.alerts.List() {
+		if state.Firing {
+			t.Errorf("alert %s is still firing", state.Labels.Rule)
+		}
+	}
+}
+
+func TestLanguageAnnotation(t *testing.T) {
+	o := instanceOverrides{Tresholds: map[string]string{}}
+	if err := o.set("language", ""); err == nil {
+		t.Errorf("empty language was accepted")
+	}
+	if err := o.set("language", "EN"); err != nil || o.Language != "en" {
+		t.Errorf("language = %q, %v", o.Language, err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type apiServer struct {
+	alertServer *alertServer
+	username    string
+	password    string
+}
+
+// StartApi serves the API when username and password are set and the acknowledgement links when those are enabled.
+func (a *alertServer) StartApi(port int, username, password string) {
+	api := &apiServer{
+		alertServer: a,
+		username:    username,
+		password:    password,
+	}
+
+	mux := http.NewServeMux()
+	if username != "" && password != "" {
+		mux.HandleFunc("/api/alerts", api.authenticated(api.handleAlerts))
+		mux.HandleFunc("/api/silences", api.authenticated(api.sharedState(api.handleSilences)))
+		mux.HandleFunc("/api/silences/", api.authenticated(api.sharedState(api.handleSilence)))
+		mux.HandleFunc("/api/acknowledgements", api.authenticated(api.sharedState(api.handleAcknowledgements)))
+		mux.HandleFunc("/api/outbox", api.authenticated(api.handleOutbox))
+		mux.HandleFunc("/api/deadletters", api.authenticated(api.handleDeadLetters))
+		mux.HandleFunc("/api/alertmanager", api.authenticated(api.handleAlertmanagerWebhook))
+	} else {
+		log.Println("API_USER/API_PASSWORD not set. The API is disabled.")
+	}
+	if a.ackLinks != nil {
+		mux.HandleFunc("/acknowledge", api.sharedState(api.handleAckLink))
+	}
+
+	go func() {
+		log.Printf("API listening on port %v\n", port)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", port), mux))
+	}()
+}
+
+func (api *apiServer) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(api.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(api.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cfServiceAlert"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// sharedState refuses requests for silences and acknowledgements when they would only be stored on the local disk of
+// one of multiple instances: the other instances wouldn't see them and they would be lost on restage.
+func (api *apiServer) sharedState(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !api.alertServer.sharedState && api.alertServer.nodeCount() > 1 {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("Silences and acknowledgements need a STATE_PATH shared by all instances when running more than one instance"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (api *apiServer) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+
+	alerts := api.alertServer.alerts.List()
+	api.otherNodes("alerts-", func(name string) error {
+		states := make(map[string]alertState)
+		if err := api.alertServer.store.Load(name, &states); err != nil {
+			return err
+		}
+		for _, state := range states {
+			alerts = append(alerts, state)
+		}
+		return nil
+	})
+
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+func (api *apiServer) handleOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+
+	entries := api.alertServer.outbox.Entries()
+	api.otherNodes("outbox-", func(name string) error {
+		nodeEntries := make(map[string]outboxEntry)
+		if err := api.alertServer.store.Load(name, &nodeEntries); err != nil {
+			return err
+		}
+		for _, entry := range nodeEntries {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (api *apiServer) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+
+	deadLetters := api.alertServer.outbox.DeadLetters()
+	api.otherNodes("deadletters-", func(name string) error {
+		var nodeDeadLetters []outboxEntry
+		if err := api.alertServer.store.Load(name, &nodeDeadLetters); err != nil {
+			return err
+		}
+		deadLetters = append(deadLetters, nodeDeadLetters...)
+		return nil
+	})
+
+	writeJSON(w, http.StatusOK, deadLetters)
+}
+
+// otherNodes calls load with the state name of every other instance, which is prefix followed by the instance index,
+// so the API shows the alerts and deliveries of all instances. Without a shared STATE_PATH the other instances' state
+// can't be read and only the instance answering the request is shown.
+func (api *apiServer) otherNodes(prefix string, load func(name string) error) {
+	if !api.alertServer.sharedState {
+		return
+	}
+
+	for i := 0; i < api.alertServer.nodeCount(); i++ {
+		node := strconv.Itoa(i)
+		if node == api.alertServer.node {
+			continue
+		}
+		if err := load(prefix + node); err != nil {
+			log.Printf("Unable to load %s: %v\n", prefix+node, err)
+		}
+	}
+}
+
+func (api *apiServer) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := api.alertServer.silences.List()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, silences)
+	case http.MethodPost:
+		var sil silence
+		if err := json.NewDecoder(r.Body).Decode(&sil); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid silence: %v", err))
+			return
+		}
+
+		sil, err := api.alertServer.silences.Add(sil, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid silence: %v", err))
+			return
+		}
+
+		log.Printf("Silence %s created by %s: %s\n", sil.Id, sil.CreatedBy, sil.Comment)
+		writeJSON(w, http.StatusCreated, sil)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+	}
+}
+
+func (api *apiServer) handleSilence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/silences/")
+
+	switch r.Method {
+	case http.MethodGet:
+		sil, found, err := api.alertServer.silences.Get(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Silence %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, sil)
+	case http.MethodDelete:
+		found, err := api.alertServer.silences.Expire(id, time.Now())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Silence %s not found", id))
+			return
+		}
+
+		log.Printf("Silence %s expired\n", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+	}
+}
+
+func (api *apiServer) handleAcknowledgements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		acks, err := api.alertServer.acknowledgements.List()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, acks)
+	case http.MethodPost:
+		var ack acknowledgement
+		if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid acknowledgement: %v", err))
+			return
+		}
+
+		ack, err := api.alertServer.acknowledgements.Add(ack, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid acknowledgement: %v", err))
+			return
+		}
+
+		log.Printf("Alert %s acknowledged by %s\n", ack.Alert, ack.AcknowledgedBy)
+		writeJSON(w, http.StatusCreated, ack)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
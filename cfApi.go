@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// Helpers for CF API endpoints and fields the cfclient library doesn't cover.
+
+type lastOperation struct {
+	Type        string    `json:"type"`
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (o lastOperation) InProgress() bool {
+	return o.State == "initial" || o.State == "in progress"
+}
+
+// serviceInstance adds the last operation, which cfclient.V3ServiceInstance lacks.
+type serviceInstance struct {
+	cfclient.V3ServiceInstance
+	LastOperation lastOperation `json:"last_operation"`
+}
+
+func listServiceInstances(client *cfclient.Client) ([]serviceInstance, error) {
+	var serviceInstances []serviceInstance
+
+	err := listV3Resources(client, "/v3/service_instances", func(resources json.RawMessage) error {
+		var page []serviceInstance
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		serviceInstances = append(serviceInstances, page...)
+		return nil
+	})
+
+	return serviceInstances, err
+}
+
+// listV3Resources walks all pages of a v3 list endpoint and hands the resources of each page to appendPage.
+func listV3Resources(client *cfclient.Client, requestURL string, appendPage func(json.RawMessage) error) error {
+	for requestURL != "" {
+		resp, err := client.DoRequest(client.NewRequest("GET", requestURL))
+		if err != nil {
+			return fmt.Errorf("Error requesting %s: %v", requestURL, err)
+		}
+
+		var data struct {
+			Pagination cfclient.Pagination `json:"pagination"`
+			Resources  json.RawMessage     `json:"resources"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Error requesting %s, response code: %d", requestURL, resp.StatusCode)
+		}
+		if err != nil {
+			return fmt.Errorf("Error parsing response of %s: %v", requestURL, err)
+		}
+
+		if err := appendPage(data.Resources); err != nil {
+			return fmt.Errorf("Error parsing response of %s: %v", requestURL, err)
+		}
+
+		requestURL = ""
+		if data.Pagination.Next.Href != "" {
+			next, err := url.Parse(data.Pagination.Next.Href)
+			if err != nil {
+				return err
+			}
+			requestURL = next.RequestURI()
+		}
+	}
+
+	return nil
+}
+
+var (
+	spaceRoles = map[string]bool{"space_developer": true, "space_manager": true, "space_auditor": true, "space_supporter": true}
+	orgRoles   = map[string]bool{"organization_manager": true, "organization_auditor": true, "organization_billing_manager": true, "organization_user": true}
+)
+
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if !spaceRoles[role] && !orgRoles[role] {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+
+	return nil
+}
+
+// userGuidsWithRoles returns the guids of users having any of the roles in the space or in the org. When orgGuid is
+// empty the org the space belongs to is used, when spaceGuid is empty space roles are ignored.
+func userGuidsWithRoles(client *cfclient.Client, spaceGuid, orgGuid string, roles []string) ([]string, error) {
+	var spaceRoleTypes, orgRoleTypes []string
+	for _, role := range roles {
+		if spaceRoles[role] {
+			spaceRoleTypes = append(spaceRoleTypes, role)
+		} else if orgRoles[role] {
+			orgRoleTypes = append(orgRoleTypes, role)
+		}
+	}
+
+	var found []cfclient.V3Role
+	if len(spaceRoleTypes) > 0 && spaceGuid != "" {
+		spaceUserRoles, err := client.ListV3RolesByQuery(url.Values{
+			"types":       {strings.Join(spaceRoleTypes, ",")},
+			"space_guids": {spaceGuid},
+		})
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, spaceUserRoles...)
+	}
+
+	if len(orgRoleTypes) > 0 {
+		if orgGuid == "" {
+			space, err := client.GetV3SpaceByGUID(spaceGuid)
+			if err != nil {
+				return nil, err
+			}
+			orgGuid = space.Relationships["organization"].Data.GUID
+		}
+
+		orgUserRoles, err := client.ListV3RolesByQuery(url.Values{
+			"types":              {strings.Join(orgRoleTypes, ",")},
+			"organization_guids": {orgGuid},
+		})
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, orgUserRoles...)
+	}
+
+	seen := make(map[string]bool)
+	var userGuids []string
+	for _, role := range found {
+		guid := role.Relationships["user"].Data.GUID
+		if guid != "" && !seen[guid] {
+			seen[guid] = true
+			userGuids = append(userGuids, guid)
+		}
+	}
+
+	return userGuids, nil
+}
+
+// uaaStatusError is returned when UAA answers a users request with another status than 200 OK.
+type uaaStatusError struct {
+	statusCode int
+}
+
+func (e uaaStatusError) Error() string {
+	return fmt.Sprintf("Error requesting users from UAA, response code: %d", e.statusCode)
+}
+
+// permanent tells whether UAA rejected the request itself, which won't change by trying again.
+func (e uaaStatusError) permanent() bool {
+	return e.statusCode >= 400 && e.statusCode < 500 && e.statusCode != http.StatusTooManyRequests
+}
+
+// userEmails looks up the primary email address of users in UAA. Users without an email address (for example
+// client credentials) are skipped. The CF client needs the scim.read scope for this.
+func userEmails(client *cfclient.Client, userGuids []string) ([]string, error) {
+	const batchSize = 50
+	var emails []string
+
+	for start := 0; start < len(userGuids); start += batchSize {
+		end := start + batchSize
+		if end > len(userGuids) {
+			end = len(userGuids)
+		}
+
+		var filter []string
+		for _, guid := range userGuids[start:end] {
+			filter = append(filter, fmt.Sprintf("id eq %q", guid))
+		}
+
+		query := url.Values{
+			"filter":     {strings.Join(filter, " or ")},
+			"attributes": {"id,emails"},
+			"count":      {strconv.Itoa(batchSize)},
+		}
+
+		resp, err := client.Config.HttpClient.Get(strings.TrimSuffix(client.Endpoint.TokenEndpoint, "/") + "/Users?" + query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("Error requesting users from UAA: %v", err)
+		}
+
+		var data struct {
+			Resources []struct {
+				Emails []struct {
+					Value   string `json:"value"`
+					Primary bool   `json:"primary"`
+				} `json:"emails"`
+			} `json:"resources"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, uaaStatusError{resp.StatusCode}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing users from UAA: %v", err)
+		}
+
+		for _, user := range data.Resources {
+			if len(user.Emails) == 0 {
+				continue
+			}
+
+			email := user.Emails[0].Value
+			for _, e := range user.Emails {
+				if e.Primary {
+					email = e.Value
+				}
+			}
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, nil
+}
+
+var errServiceInstanceNotFound = errors.New("Service instance not found")
+
+func getServiceInstance(client *cfclient.Client, guid string) (serviceInstance, error) {
+	var serviceInstances []serviceInstance
+
+	err := listV3Resources(client, "/v3/service_instances?guids="+url.QueryEscape(guid), func(resources json.RawMessage) error {
+		return json.Unmarshal(resources, &serviceInstances)
+	})
+	if err != nil {
+		return serviceInstance{}, err
+	}
+
+	if len(serviceInstances) == 0 {
+		return serviceInstance{}, fmt.Errorf("%w: %s", errServiceInstanceNotFound, guid)
+	}
+
+	return serviceInstances[0], nil
+}
+
+// spaceRef is a space with the name of its org.
+type spaceRef struct {
+	Guid    string
+	Name    string
+	OrgGuid string
+	OrgName string
+}
+
+// sharedSpaces returns the spaces a service instance is shared with.
+func sharedSpaces(client *cfclient.Client, instanceGuid string) ([]spaceRef, error) {
+	requestURL := fmt.Sprintf("/v3/service_instances/%s/relationships/shared_spaces?fields[space]=name,guid,relationships.organization&fields[space.organization]=name,guid", url.PathEscape(instanceGuid))
+	resp, err := client.DoRequest(client.NewRequest("GET", requestURL))
+	if err != nil {
+		return nil, fmt.Errorf("Error requesting %s: %v", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Included struct {
+			Spaces []struct {
+				Guid          string `json:"guid"`
+				Name          string `json:"name"`
+				Relationships struct {
+					Organization struct {
+						Data struct {
+							Guid string `json:"guid"`
+						} `json:"data"`
+					} `json:"organization"`
+				} `json:"relationships"`
+			} `json:"spaces"`
+			Organizations []struct {
+				Guid string `json:"guid"`
+				Name string `json:"name"`
+			} `json:"organizations"`
+		} `json:"included"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error requesting %s, response code: %d", requestURL, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("Error parsing response of %s: %v", requestURL, err)
+	}
+
+	orgNames := make(map[string]string)
+	for _, org := range data.Included.Organizations {
+		orgNames[org.Guid] = org.Name
+	}
+
+	var spaces []spaceRef
+	for _, space := range data.Included.Spaces {
+		orgGuid := space.Relationships.Organization.Data.Guid
+		spaces = append(spaces, spaceRef{Guid: space.Guid, Name: space.Name, OrgGuid: orgGuid, OrgName: orgNames[orgGuid]})
+	}
+
+	return spaces, nil
+}
+
+// boundApp is an app with a credential binding to a service instance.
+type boundApp struct {
+	Guid      string
+	Name      string
+	SpaceGuid string
+}
+
+// boundApps returns the apps bound to a service instance.
+func boundApps(client *cfclient.Client, instanceGuid string) ([]boundApp, error) {
+	var appGuids []string
+	err := listV3Resources(client, "/v3/service_credential_bindings?type=app&service_instance_guids="+url.QueryEscape(instanceGuid), func(resources json.RawMessage) error {
+		var page []struct {
+			Relationships struct {
+				App struct {
+					Data struct {
+						Guid string `json:"guid"`
+					} `json:"data"`
+				} `json:"app"`
+			} `json:"relationships"`
+		}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, binding := range page {
+			appGuids = append(appGuids, binding.Relationships.App.Data.Guid)
+		}
+		return nil
+	})
+	if err != nil || len(appGuids) == 0 {
+		return nil, err
+	}
+
+	var apps []boundApp
+	err = listV3Resources(client, "/v3/apps?guids="+url.QueryEscape(strings.Join(appGuids, ",")), func(resources json.RawMessage) error {
+		var page []struct {
+			Guid          string `json:"guid"`
+			Name          string `json:"name"`
+			Relationships struct {
+				Space struct {
+					Data struct {
+						Guid string `json:"guid"`
+					} `json:"data"`
+				} `json:"space"`
+			} `json:"relationships"`
+		}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, app := range page {
+			apps = append(apps, boundApp{Guid: app.Guid, Name: app.Name, SpaceGuid: app.Relationships.Space.Data.Guid})
+		}
+		return nil
+	})
+
+	return apps, err
+}
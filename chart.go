@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	chartWidth  = 400
+	chartHeight = 100
+	chartMargin = 4
+)
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartBorder     = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	chartThreshold  = color.RGBA{0xd0, 0x21, 0x21, 0xff}
+	chartSeries     = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff},
+		{0x2c, 0xa0, 0x2c, 0xff},
+		{0xff, 0x7f, 0x0e, 0xff},
+		{0x94, 0x67, 0xbd, 0xff},
+	}
+)
+
+// chartConfig adds a chart of the metric over the last Range to notifications of the rule.
+type chartConfig struct {
+	Range string `json:"range"`
+	Step  string `json:"step"`
+
+	duration time.Duration
+	step     time.Duration
+}
+
+func (c *chartConfig) parse() error {
+	var err error
+	if c.duration, err = time.ParseDuration(c.Range); err != nil {
+		return fmt.Errorf("invalid chart range: %v", err)
+	}
+
+	//about one point per two pixels by default
+	c.step = c.duration / (chartWidth / 2)
+	if c.Step != "" {
+		if c.step, err = time.ParseDuration(c.Step); err != nil {
+			return fmt.Errorf("invalid chart step: %v", err)
+		}
+	}
+	if c.step < time.Second {
+		c.step = time.Second
+	}
+
+	return nil
+}
+
+// chart returns a PNG chart of the rule's metric for the instance, or nil when the rule has no chart or the metric
+// can't be retrieved.
+func (a *alertServer) chart(rule alertRule, instanceId string, now time.Time) []byte {
+	if rule.Chart == nil {
+		return nil
+	}
+
+	matrix, err := a.GetMetricRange(rule.Promq, instanceId, rule.Chart.duration, rule.Chart.step, now)
+	if err != nil {
+		log.Printf("Error getting chart data for service %s: %v\n", instanceId, err)
+		return nil
+	}
+
+	treshold, _ := strconv.ParseFloat(rule.Treshold, 64)
+	chart, err := renderChart(matrix, treshold, now.Add(-rule.Chart.duration), now)
+	if err != nil {
+		log.Printf("Error rendering chart for service %s: %v\n", instanceId, err)
+		return nil
+	}
+
+	return chart
+}
+
+// renderChart draws the series and a threshold line as a small PNG sparkline.
+func renderChart(matrix model.Matrix, threshold float64, start, end time.Time) ([]byte, error) {
+	minValue, maxValue := threshold, threshold
+	points := 0
+	for _, series := range matrix {
+		for _, p := range series.Values {
+			v := float64(p.Value)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			minValue = math.Min(minValue, v)
+			maxValue = math.Max(maxValue, v)
+			points++
+		}
+	}
+	if points == 0 {
+		return nil, fmt.Errorf("no data")
+	}
+
+	padding := (maxValue - minValue) * 0.1
+	if padding == 0 {
+		padding = 1
+	}
+	minValue -= padding
+	maxValue += padding
+
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: chartBackground}, image.Point{}, draw.Src)
+	for x := 0; x < chartWidth; x++ {
+		img.Set(x, 0, chartBorder)
+		img.Set(x, chartHeight-1, chartBorder)
+	}
+	for y := 0; y < chartHeight; y++ {
+		img.Set(0, y, chartBorder)
+		img.Set(chartWidth-1, y, chartBorder)
+	}
+
+	span := end.Sub(start).Seconds()
+	toX := func(t model.Time) int {
+		return chartMargin + int(t.Time().Sub(start).Seconds()/span*float64(chartWidth-2*chartMargin))
+	}
+	toY := func(v float64) int {
+		return chartHeight - chartMargin - int((v-minValue)/(maxValue-minValue)*float64(chartHeight-2*chartMargin))
+	}
+
+	thresholdY := toY(threshold)
+	for x := chartMargin; x < chartWidth-chartMargin; x++ {
+		if (x/6)%2 == 0 {
+			img.Set(x, thresholdY, chartThreshold)
+		}
+	}
+
+	for i, series := range matrix {
+		c := chartSeries[i%len(chartSeries)]
+		var prevX, prevY int
+		havePrev := false
+		for _, p := range series.Values {
+			v := float64(p.Value)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				havePrev = false
+				continue
+			}
+
+			x, y := toX(p.Timestamp), toY(v)
+			if havePrev {
+				drawLine(img, prevX, prevY, x, y, c)
+			} else {
+				drawLine(img, x, y, x, y, c)
+			}
+			prevX, prevY, havePrev = x, y, true
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// drawLine draws a line of two pixels wide using Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	err := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		img.SetRGBA(x0+1, y0, c)
+		img.SetRGBA(x0, y0+1, c)
+		img.SetRGBA(x0+1, y0+1, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+
+	return i
+}
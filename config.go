@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -18,18 +21,43 @@ type alertServerConfig struct {
 	GrafanaApiKey               string `envconfig:"grafana_api_key" required:"true"`
 	CheckInterval               int    `envconfig:"check_interval" default:"120"`
 	Environment                 string `envconfig:"environment_name" required:"true"`
-	NotificationServiceUrl      string `envconfig:"notification_url" required:"true"`
-	NotificationServiceUser     string `envconfig:"notification_user" required:"true"`
-	NotificationServicePassword string `envconfig:"notification_password" required:"true"`
+	NotificationServiceUrl      string `envconfig:"notification_url"`
+	NotificationServiceUser     string `envconfig:"notification_user"`
+	NotificationServicePassword string `envconfig:"notification_password"`
+	NotifiersPath               string `envconfig:"notifiers_path"`
+	OperationGracePeriod        int    `envconfig:"operation_grace_period" default:"0"`
+	OutboxRetryInitial          int    `envconfig:"outbox_retry_initial" default:"30"`
+	OutboxRetryMax              int    `envconfig:"outbox_retry_max" default:"3600"`
+	OutboxMaxAge                int    `envconfig:"outbox_max_age" default:"86400"`
+	StatePath                   string `envconfig:"state_path"`
+	Port                        int    `envconfig:"port" default:"8080"`
+	ApiUser                     string `envconfig:"api_user"`
+	ApiPassword                 string `envconfig:"api_password"`
+	AckSecret                   string `envconfig:"ack_secret"`
+	AckLinkValidity             int    `envconfig:"ack_link_validity" default:"604800"`
+	ExternalURL                 string `envconfig:"external_url"`
 }
 
-func alertServerConfigLoad() (alertServerConfig, alertRules, error) {
+// rulesConfig is the content of the rules file. Older rules files only contain the rules map, these are still accepted.
+type rulesConfig struct {
+	Rules         alertRules    `json:"rules"`
+	QuietHours    []quietHours  `json:"quiet_hours"`
+	InhibitRules  []inhibitRule `json:"inhibit_rules"`
+	FlapDetection flapDetection `json:"flap_detection"`
+
+	AlertmanagerReceiver alertmanagerReceiverConfig `json:"alertmanager_receiver"`
+	RateLimits           rateLimitsConfig           `json:"rate_limits"`
+	Grouping             groupingConfig             `json:"grouping"`
+	Routes               []route                    `json:"routes"`
+	Digest               *digestConfig              `json:"digest"`
+}
+
+func alertServerConfigLoad() (alertServerConfig, rulesConfig, error) {
 	var config alertServerConfig
-	rules := make(alertRules)
 
 	err := envconfig.Process("", &config)
 	if err != nil {
-		return alertServerConfig{}, nil, err
+		return alertServerConfig{}, rulesConfig{}, err
 	}
 
 	if config.CFUser == "" && config.CFClient == "" {
@@ -40,15 +68,158 @@ func alertServerConfigLoad() (alertServerConfig, alertRules, error) {
 		log.Println("Both CF_USER and CF_CLIENT are set. I'll use CF_CLIENT and ignore CF_USER.")
 	}
 
-	inBuf, err := ioutil.ReadFile(config.RulesPath)
-	if err != nil {
-		return alertServerConfig{}, nil, err
+	if config.NotifiersPath == "" && (config.NotificationServiceUrl == "" || config.NotificationServiceUser == "" || config.NotificationServicePassword == "") {
+		log.Fatal("Please set NOTIFICATION_URL/NOTIFICATION_USER/NOTIFICATION_PASSWORD or NOTIFIERS_PATH")
 	}
 
-	err = json.Unmarshal(inBuf, &rules)
+	rules, err := rulesConfigLoad(config.RulesPath)
 	if err != nil {
-		return alertServerConfig{}, nil, err
+		return alertServerConfig{}, rulesConfig{}, err
 	}
 
 	return config, rules, nil
 }
+
+// notifierConfigs returns the configured sinks. Without a notifiers file notifications go to the cfNotificationService
+// configured through NOTIFICATION_URL/NOTIFICATION_USER/NOTIFICATION_PASSWORD.
+func (config alertServerConfig) notifierConfigs() ([]notifierConfig, error) {
+	if config.NotifiersPath != "" {
+		return notifiersConfigLoad(config.NotifiersPath)
+	}
+
+	return []notifierConfig{{
+		Name:     "cfnotificationservice",
+		Type:     "cfnotificationservice",
+		URL:      config.NotificationServiceUrl,
+		Username: config.NotificationServiceUser,
+		Password: config.NotificationServicePassword,
+	}}, nil
+}
+
+func (config alertServerConfig) outboxConfig() outboxConfig {
+	return outboxConfig{
+		RetryInitial:  time.Second * time.Duration(config.OutboxRetryInitial),
+		RetryMax:      time.Second * time.Duration(config.OutboxRetryMax),
+		MaxAge:        time.Second * time.Duration(config.OutboxMaxAge),
+		MaxDeadLetter: 1000,
+	}
+}
+
+func rulesConfigLoad(path string) (rulesConfig, error) {
+	var rules rulesConfig
+
+	inBuf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rulesConfig{}, err
+	}
+
+	var sections map[string]json.RawMessage
+	err = json.Unmarshal(inBuf, &sections)
+	if err != nil {
+		return rulesConfig{}, err
+	}
+
+	if _, ok := sections["rules"]; ok {
+		err = json.Unmarshal(inBuf, &rules)
+	} else {
+		err = json.Unmarshal(inBuf, &rules.Rules)
+	}
+	if err != nil {
+		return rulesConfig{}, err
+	}
+
+	quietHoursRefs := make(map[string]int) //the number of rules referring to the quiet hours
+	for i := range rules.QuietHours {
+		if err := rules.QuietHours[i].parse(); err != nil {
+			return rulesConfig{}, err
+		}
+		quietHoursRefs[rules.QuietHours[i].Name] = 0
+	}
+
+	for i := range rules.InhibitRules {
+		if err := rules.InhibitRules[i].parse(); err != nil {
+			return rulesConfig{}, err
+		}
+	}
+
+	for i := range rules.Routes {
+		if err := rules.Routes[i].parse(); err != nil {
+			return rulesConfig{}, err
+		}
+	}
+
+	if err := rules.FlapDetection.parse(); err != nil {
+		return rulesConfig{}, err
+	}
+
+	if err := rules.AlertmanagerReceiver.parse(); err != nil {
+		return rulesConfig{}, err
+	}
+
+	if err := rules.RateLimits.parse(); err != nil {
+		return rulesConfig{}, err
+	}
+
+	if rules.Digest != nil && !rules.Grouping.Enabled() {
+		grouping, err := rules.Digest.grouping()
+		if err != nil {
+			return rulesConfig{}, err
+		}
+		rules.Grouping = grouping
+	}
+
+	if err := rules.Grouping.parse(); err != nil {
+		return rulesConfig{}, err
+	}
+
+	for service, ruleSet := range rules.Rules {
+		for i := range ruleSet {
+			rule := &ruleSet[i]
+			for _, name := range rule.QuietHours {
+				if _, ok := quietHoursRefs[name]; !ok {
+					return rulesConfig{}, fmt.Errorf("rule %s for service %s refers to unknown quiet hours %s", rule.Name, service, name)
+				}
+				quietHoursRefs[name]++
+			}
+
+			var err error
+			if rule.NotifyInterval != "" {
+				if rule.notifyInterval, err = time.ParseDuration(rule.NotifyInterval); err != nil {
+					return rulesConfig{}, fmt.Errorf("rule %s for service %s: invalid notification_interval: %v", rule.Name, service, err)
+				}
+			}
+			if rule.targets, err = parseTargets(rule.Targets); err != nil {
+				return rulesConfig{}, fmt.Errorf("rule %s for service %s: %v", rule.Name, service, err)
+			}
+			if err := parseEscalations(rule.Escalations); err != nil {
+				return rulesConfig{}, fmt.Errorf("rule %s for service %s: %v", rule.Name, service, err)
+			}
+			if err := validateFormat(rule.Format); err != nil {
+				return rulesConfig{}, fmt.Errorf("rule %s for service %s: %v", rule.Name, service, err)
+			}
+			translations := make(map[string]ruleTranslation)
+			for language, t := range rule.Translations {
+				translations[strings.ToLower(language)] = t
+			}
+			rule.Translations = translations
+			if rule.Chart != nil {
+				if err := rule.Chart.parse(); err != nil {
+					return rulesConfig{}, fmt.Errorf("rule %s for service %s: %v", rule.Name, service, err)
+				}
+			}
+			if rule.Repeat != nil {
+				if err := rule.Repeat.parse(); err != nil {
+					return rulesConfig{}, fmt.Errorf("rule %s for service %s: %v", rule.Name, service, err)
+				}
+			}
+		}
+	}
+
+	for _, q := range rules.QuietHours {
+		if len(q.Orgs) == 0 && quietHoursRefs[q.Name] == 0 {
+			log.Printf("Quiet hours %s apply to no rules, list them in the quiet_hours of rules or set orgs\n", q.Name)
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// consumerSpace is a space other than the owning space which is notified about alerts of a service instance.
+type consumerSpace struct {
+	spaceRef
+	BoundApps []string
+}
+
+// boundAppNames returns the sorted names of the apps bound to the service instance per space guid when the rule
+// notifies bound app spaces, nil otherwise. Errors are logged, the templates then get no bound apps.
+func (a *alertServer) boundAppNames(info instanceInfo, rule alertRule) map[string][]string {
+	if !rule.NotifyBoundAppSpaces {
+		return nil
+	}
+
+	apps, err := boundApps(a.cfClient, info.Instance.Guid)
+	if err != nil {
+		log.Printf("Error getting bound apps of service %v: %v\n", info.Instance.Guid, err)
+		return nil
+	}
+
+	appNames := make(map[string][]string)
+	for _, app := range apps {
+		appNames[app.SpaceGuid] = append(appNames[app.SpaceGuid], app.Name)
+	}
+	for _, names := range appNames {
+		sort.Strings(names)
+	}
+
+	return appNames
+}
+
+// consumerSpaces returns the spaces the service instance is shared with when the rule notifies shared spaces, and
+// the spaces with apps bound to the instance (in appNames) when the rule notifies those. Apps can only bind to
+// instances in their own space or shared with their space, so the latter are a subset of the former.
+func (a *alertServer) consumerSpaces(info instanceInfo, rule alertRule, appNames map[string][]string) ([]consumerSpace, error) {
+	if !rule.NotifySharedSpaces && !rule.NotifyBoundAppSpaces {
+		return nil, nil
+	}
+
+	shared, err := sharedSpaces(a.cfClient, info.Instance.Guid)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting shared spaces: %v", err)
+	}
+
+	var spaces []consumerSpace
+	for _, space := range shared {
+		names := appNames[space.Guid]
+		if len(names) == 0 && !rule.NotifySharedSpaces {
+			continue
+		}
+
+		spaces = append(spaces, consumerSpace{spaceRef: space, BoundApps: names})
+	}
+
+	return spaces, nil
+}
+
+// consumerMessages renders msg for each consumer space of the service instance, in the language of that space. The
+// templates get Recipient "consumer", the consuming space in RecipientSpace/RecipientOrg and the apps in that space
+// bound to the instance in BoundApps.
+func (a *alertServer) consumerMessages(info instanceInfo, rule alertRule, resolved bool, msg NotificationMessage, metricValue string, appNames map[string][]string) []NotificationMessage {
+	spaces, err := a.consumerSpaces(info, rule, appNames)
+	if err != nil {
+		log.Printf("Error getting consumer spaces of service %v: %v\n", info.Instance.Guid, err)
+		return nil
+	}
+
+	var msgs []NotificationMessage
+	for _, space := range spaces {
+		localized := rule.templatesFor(a.spaceLanguage(rule, space.spaceRef), resolved)
+		templData := a.templateData(info, localized, metricValue)
+		templData.Recipient = "consumer"
+		templData.RecipientSpace = space.Name
+		templData.RecipientOrg = space.OrgName
+		templData.BoundApps = space.BoundApps
+		subject, message, err := localized.render(templData)
+		if err != nil {
+			log.Printf("Error generating notification for consumer space %s: %v\n", space.Guid, err)
+			continue
+		}
+
+		consumerMsg := msg
+		consumerMsg.Id = fmt.Sprintf("%s-%s", strings.TrimSpace(msg.Id), space.Guid)
+		consumerMsg.Subject = subject
+		consumerMsg.Message = message
+		consumerMsg.Target.Type = "space"
+		consumerMsg.Target.Id = space.Guid
+		//routes and targets apply to the consuming space
+		consumerMsg.Labels.Space = space.Name
+		consumerMsg.Labels.SpaceGuid = space.Guid
+		consumerMsg.Labels.Org = space.OrgName
+		consumerMsg.Labels.OrgGuid = space.OrgGuid
+		msgs = append(msgs, consumerMsg)
+	}
+
+	return msgs
+}
+
+// spaceLanguage returns the language set by annotation on a space or its org. It's only looked up for rules with
+// translations.
+func (a *alertServer) spaceLanguage(rule alertRule, space spaceRef) string {
+	if len(rule.Translations) == 0 {
+		return ""
+	}
+
+	v3Space, err := a.cfClient.GetV3SpaceByGUID(space.Guid)
+	if err != nil {
+		log.Printf("Error getting space %s: %v\n", space.Guid, err)
+		return ""
+	}
+	if language := v3Space.Metadata.Annotations[annotationPrefix+"language"]; language != "" {
+		return language
+	}
+
+	org, err := a.cfClient.GetV3OrganizationByGUID(space.OrgGuid)
+	if err != nil {
+		log.Printf("Error getting org %s: %v\n", space.OrgGuid, err)
+		return ""
+	}
+
+	return org.Metadata.Annotations[annotationPrefix+"language"]
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const defaultEscalationMessage = "Alert \"{{.AlertName}}\" in environment {{.EnvironmentName}} for service instance {{.InstanceName}} in Cloudfoundry org/space: {{.OrgName}}/{{.SpaceName}} is firing since {{.FiringSince.Format \"2006-01-02 15:04 MST\"}} and has not been acknowledged."
+
+// escalationStep notifies Targets through Sinks (all sinks when empty) when an alert is still firing and not
+// acknowledged After it started firing.
+type escalationStep struct {
+	After   string   `json:"after"`
+	Targets []string `json:"targets"`
+	Sinks   []string `json:"sinks"`
+	Subject string   `json:"subject"`
+	Message string   `json:"message"`
+
+	after   time.Duration
+	targets []target
+}
+
+func (s *escalationStep) parse() error {
+	var err error
+	if s.after, err = time.ParseDuration(s.After); err != nil {
+		return fmt.Errorf("invalid escalation after: %v", err)
+	}
+	if s.targets, err = parseTargets(s.Targets); err != nil {
+		return fmt.Errorf("invalid escalation: %v", err)
+	}
+
+	return nil
+}
+
+func parseEscalations(steps []escalationStep) error {
+	for i := range steps {
+		if err := steps[i].parse(); err != nil {
+			return err
+		}
+		if i > 0 && steps[i].after <= steps[i-1].after {
+			return fmt.Errorf("escalation steps must be ordered by after")
+		}
+	}
+
+	return nil
+}
+
+// escalations returns the escalation steps of the rule, or else those of the first matching route having any.
+func (a *alertServer) escalations(rule alertRule, labels alertLabels) []escalationStep {
+	if len(rule.Escalations) > 0 {
+		return rule.Escalations
+	}
+
+	for _, r := range a.routes {
+		if len(r.Escalations) > 0 && r.matches(labels) {
+			return r.Escalations
+		}
+	}
+
+	return nil
+}
+
+// escalate sends the latest escalation step that is due for a firing alert and wasn't sent yet. Acknowledged
+// alerts are not escalated. A step counts as sent once the outbox delivered or queued it.
+func (a *alertServer) escalate(info instanceInfo, rule alertRule, state *alertState, metricValue string, now time.Time) {
+	steps := a.escalations(rule, state.Labels)
+
+	due := state.Escalations
+	for due < len(steps) && now.Sub(state.FiringSince) >= steps[due].after {
+		due++
+	}
+	if due == state.Escalations {
+		return
+	}
+
+	if ack, ok := a.activeAcknowledgements[state.Labels.Key()]; ok && ack.Covers(*state) {
+		return
+	}
+
+	level := due - 1
+	step := steps[level]
+	if step.Subject != "" {
+		rule.Subject = step.Subject
+	} else {
+		rule.Subject = "Escalated: " + rule.Subject
+	}
+	if step.Message != "" {
+		rule.Message = step.Message
+	} else {
+		rule.Message = defaultEscalationMessage
+	}
+
+	templData := a.templateData(info, rule, metricValue)
+	templData.BoundApps = a.boundAppNames(info, rule)[info.Space.GUID]
+	templData.FiringSince = state.FiringSince
+	templData.EscalationLevel = level + 1
+	subject, message, err := rule.render(templData)
+	if err != nil {
+		log.Println("Error generating escalation: ", err)
+		return
+	}
+
+	msg := NotificationMessage{
+		Id:       fmt.Sprintf("%s-%s-escalation-%d", info.Instance.Guid, rule.Name, level+1),
+		Subject:  subject,
+		Message:  message,
+		Target:   NotificationMessageTarget{Type: "space", Environment: a.environment, Id: info.Space.GUID},
+		Status:   statusFiring,
+		Labels:   state.Labels,
+		StartsAt: state.FiringSince,
+		Format:   rule.Format,
+	}
+
+	a.addAckLink(&msg, state.Labels, now)
+
+	log.Printf("Escalating alert %s for service %s to level %d\n", rule.Name, info.Instance.Guid, level+1)
+	sent := true
+	for _, d := range targetDeliveries(msg, step.targets, step.Sinks) {
+		if err := a.outbox.Send(d.msg, d.sinks); err != nil {
+			log.Println("Escalation not sent: ", err)
+			sent = sent && notified(err)
+		}
+	}
+
+	//deliveries which failed for now are queued in the outbox, the step is tried again on the next check when
+	//delivering it failed for good
+	if sent {
+		state.Escalations = due
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestEscalationStepsAreOrdered(t *testing.T) {
+	steps := []escalationStep{{After: "1h"}, {After: "30m"}}
+	if err := parseEscalations(steps); err == nil {
+		t.Errorf("unordered escalation steps were accepted")
+	}
+}
+
+func TestEscalationAdvancesWhenSent(t *testing.T) {
+	var failure error
+	var sent []string
+	a := &alertServer{outbox: newTestOutbox(t, notifiers{{name: "pager", id: "pager", notifier: funcNotifier(func(msg NotificationMessage) error {
+		sent = append(sent, msg.Id)
+		return failure
+	})}})}
+
+	info := instanceInfo{
+		Instance: cfclient.V3ServiceInstance{Guid: "guid-1", Name: "my_db"},
+		Space:    &cfclient.V3Space{Name: "space"},
+		Org:      &cfclient.V3Organization{Name: "org"},
+	}
+	rule := alertRule{Name: "disk", Subject: "Disk almost full", Escalations: []escalationStep{{After: "30m"}, {After: "2h"}}}
+	if err := parseEscalations(rule.Escalations); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := alertState{Labels: testMessage().Labels, Firing: true, FiringSince: start}
+
+	for _, step := range []struct {
+		name            string
+		after           time.Duration
+		failure         error
+		wantEscalations int
+		wantSent        int
+	}{
+		{"not due yet", 10 * time.Minute, nil, 0, 0},
+		{"rejected for good", 40 * time.Minute, permanentError{fmt.Errorf("bad request")}, 0, 1},
+		{"sent again on the next check", 41 * time.Minute, nil, 1, 2},
+		{"sent once", 42 * time.Minute, nil, 1, 2},
+		{"queued for a retry", 3 * time.Hour, fmt.Errorf("connection refused"), 2, 3},
+	} {
+		failure = step.failure
+		a.escalate(info, rule, &state, "95", start.Add(step.after))
+		if state.Escalations != step.wantEscalations || len(sent) != step.wantSent {
+			t.Errorf("%s: escalations = %d and sent %v, want %d and %d sent", step.name, state.Escalations, sent, step.wantEscalations, step.wantSent)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFlappingSubject = "({{.EnvironmentName}}) Alert {{.AlertName}} for service {{.InstanceName}} is flapping"
+	defaultFlappingMessage = "Alert \"{{.AlertName}}\" in environment {{.EnvironmentName}} for your service instance {{.InstanceName}} in Cloudfoundry org/space: {{.OrgName}}/{{.SpaceName}} keeps firing and clearing. You won't receive notifications for this alert until it has stabilized."
+)
+
+// flapDetection marks an alert as flapping when it changes state more than MaxTransitions times within Window. A
+// flapping alert sends one notification and is then quiet until it hasn't changed state for StableFor.
+type flapDetection struct {
+	MaxTransitions int    `json:"max_transitions"`
+	Window         string `json:"window"`
+	StableFor      string `json:"stable_for"`
+	Subject        string `json:"subject"`
+	Message        string `json:"message"`
+
+	Translations map[string]flappingTranslation `json:"translations"` //keyed by language
+
+	window    time.Duration
+	stableFor time.Duration
+}
+
+// flappingTranslation replaces the flapping templates for tenants using another language, like rule translations.
+type flappingTranslation struct {
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+func (f *flapDetection) parse() error {
+	if !f.Enabled() {
+		return nil
+	}
+
+	var err error
+	if f.window, err = time.ParseDuration(f.Window); err != nil {
+		return fmt.Errorf("invalid flap_detection window: %v", err)
+	}
+
+	f.stableFor = f.window
+	if f.StableFor != "" {
+		if f.stableFor, err = time.ParseDuration(f.StableFor); err != nil {
+			return fmt.Errorf("invalid flap_detection stable_for: %v", err)
+		}
+	}
+
+	if f.Subject == "" {
+		f.Subject = defaultFlappingSubject
+	}
+	if f.Message == "" {
+		f.Message = defaultFlappingMessage
+	}
+
+	translations := make(map[string]flappingTranslation)
+	for language, t := range f.Translations {
+		translations[strings.ToLower(language)] = t
+	}
+	f.Translations = translations
+
+	return nil
+}
+
+// templatesFor returns the flapping subject and message for language.
+func (f flapDetection) templatesFor(language string) (string, string) {
+	subject, message := f.Subject, f.Message
+	if t, ok := f.Translations[strings.ToLower(language)]; ok {
+		if t.Subject != "" {
+			subject = t.Subject
+		}
+		if t.Message != "" {
+			message = t.Message
+		}
+	}
+
+	return subject, message
+}
+
+func (f flapDetection) Enabled() bool {
+	return f.MaxTransitions > 0
+}
+
+// update records a state transition and decides whether the alert is (still) flapping. When flapping ends after a
+// flapping notification was sent, state.stabilized is set so the tenant gets told how the alert ended up.
+func (f flapDetection) update(state *alertState, transitioned bool, now time.Time) {
+	state.stabilized = false
+	if !f.Enabled() {
+		return
+	}
+
+	if transitioned {
+		state.Transitions = append(state.Transitions, now)
+	}
+
+	//the last transition is kept while flapping, as stable_for may be longer than the window
+	var last time.Time
+	if len(state.Transitions) > 0 {
+		last = state.Transitions[len(state.Transitions)-1]
+	}
+
+	var recent []time.Time
+	for _, t := range state.Transitions {
+		if now.Sub(t) < f.window {
+			recent = append(recent, t)
+		}
+	}
+	state.Transitions = recent
+
+	if !state.Flapping && len(state.Transitions) > f.MaxTransitions {
+		state.Flapping = true
+		state.FlappingNotified = false
+	} else if state.Flapping && (last.IsZero() || now.Sub(last) >= f.stableFor) {
+		state.stabilized = state.FlappingNotified
+		state.Flapping = false
+		state.FlappingNotified = false
+	}
+
+	if state.Flapping && len(state.Transitions) == 0 {
+		state.Transitions = []time.Time{last}
+	}
+}
+
+// notifyFlapping sends a single notification when an alert starts flapping.
+func (a *alertServer) notifyFlapping(info instanceInfo, rule alertRule, state alertState, now time.Time) {
+	defer a.alerts.Put(state)
+
+	if state.FlappingNotified {
+		return
+	}
+
+	if sil := matchSilence(a.activeSilences, state.Labels, now); sil != nil {
+		log.Printf("Flapping alert %s for service %s is silenced by %s\n", rule.Name, info.Instance.Guid, sil.Id)
+		return
+	}
+
+	rule.Subject, rule.Message = a.flapDetection.templatesFor(info.Overrides.Language)
+	msg, err := rule.GenerateMessageForSpace(*a.cfClient, info.Instance, 0, a.environment)
+	if err != nil {
+		log.Println("Error generating notification: ", err)
+		return
+	}
+	msg.Id = fmt.Sprintf("%s-%s-flapping", info.Instance.Guid, rule.Name)
+	msg.Status = statusFlapping
+	msg.Labels = state.Labels
+	msg.StartsAt = now
+
+	log.Printf("Alert %s for service %s is flapping\n", rule.Name, info.Instance.Guid)
+	if err := a.send(msg); err != nil {
+		log.Println("Notification not sent: ", err)
+		if !notified(err) {
+			return
+		}
+	}
+
+	state.FlappingNotified = true
+	state.LastNotified = now
+}
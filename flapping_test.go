@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlapDetection(t *testing.T) {
+	f := flapDetection{MaxTransitions: 3, Window: "10m", StableFor: "30m"}
+	if err := f.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var state alertState
+
+	for _, step := range []struct {
+		name         string
+		after        time.Duration
+		transitioned bool
+		wantFlapping bool
+	}{
+		{"first transition", 0, true, false},
+		{"second transition", time.Minute, true, false},
+		{"third transition", 2 * time.Minute, true, false},
+		{"fourth transition within the window", 3 * time.Minute, true, true},
+		{"stable, but not for long enough", 20 * time.Minute, false, true},
+		{"stable for long enough", 33 * time.Minute, false, false},
+		{"transitions spread out over more than the window", 40 * time.Minute, true, false},
+		{"", 46 * time.Minute, true, false},
+		{"", 52 * time.Minute, true, false},
+		{"", 58 * time.Minute, true, false},
+	} {
+		state.FlappingNotified = true
+		f.update(&state, step.transitioned, start.Add(step.after))
+
+		if state.Flapping != step.wantFlapping {
+			t.Fatalf("%s (+%v): flapping = %v, want %v", step.name, step.after, state.Flapping, step.wantFlapping)
+		}
+	}
+}
+
+func TestFlapDetectionResetsNotified(t *testing.T) {
+	f := flapDetection{MaxTransitions: 1, Window: "10m"}
+	if err := f.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := alertState{FlappingNotified: true}
+	f.update(&state, true, now)
+	f.update(&state, true, now.Add(time.Minute))
+
+	if !state.Flapping || state.FlappingNotified {
+		t.Errorf("an alert that starts flapping should be notified, got %+v", state)
+	}
+}
+
+func TestFlapDetectionDisabled(t *testing.T) {
+	var f flapDetection
+	state := alertState{}
+	for i := 0; i < 10; i++ {
+		f.update(&state, true, time.Now())
+	}
+
+	if state.Flapping || len(state.Transitions) != 0 {
+		t.Errorf("disabled flap detection changed the state: %+v", state)
+	}
+}
+
+func TestStabilizedFlappingAlertResolves(t *testing.T) {
+	f := flapDetection{MaxTransitions: 2, Window: "10m"}
+	if err := f.parse(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := NewAlertStates(store, "0", f)
+
+	labels := testMessage().Labels
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, firing := range []bool{true, false, true, false} {
+		state, _ := alerts.Evaluate(labels, firing, "", start.Add(time.Duration(i)*time.Minute))
+		if i == 3 {
+			if !state.Flapping {
+				t.Fatalf("alert should be flapping")
+			}
+			//notifyFlapping
+			state.FlappingNotified = true
+			alerts.Put(state)
+		}
+	}
+
+	state, resolved := alerts.Evaluate(labels, false, "", start.Add(5*time.Minute))
+	if !state.Flapping || resolved {
+		t.Fatalf("alert stabilized too early: %+v", state)
+	}
+
+	state, resolved = alerts.Evaluate(labels, false, "", start.Add(13*time.Minute))
+	if state.Flapping || !resolved || !state.stabilized {
+		t.Errorf("an alert which stabilized in the resolved state should resolve, got %+v, resolved %v", state, resolved)
+	}
+
+	state, resolved = alerts.Evaluate(labels, false, "", start.Add(14*time.Minute))
+	if resolved || state.stabilized {
+		t.Errorf("the alert should resolve once, got %+v, resolved %v", state, resolved)
+	}
+}
+
+func TestFlappingTranslations(t *testing.T) {
+	f := flapDetection{MaxTransitions: 2, Window: "10m", Translations: map[string]flappingTranslation{"NL": {Subject: "{{.AlertName}} klappert"}}}
+	if err := f.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	if subject, message := f.templatesFor("nl"); subject != "{{.AlertName}} klappert" || message != defaultFlappingMessage {
+		t.Errorf("nl templates = %q, %q", subject, message)
+	}
+	if subject, _ := f.templatesFor("de"); subject != defaultFlappingSubject {
+		t.Errorf("de subject = %q, want the default", subject)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultGroupSubject = "({{.EnvironmentName}}) {{len .Alerts}} alerts for cloudfoundry org: {{.OrgName}}{{if .SpaceName}}/space: {{.SpaceName}}{{end}}"
+	defaultGroupMessage = "The following alerts are firing in environment {{.EnvironmentName}} for cloudfoundry org: {{.OrgName}}{{if .SpaceName}}/space: {{.SpaceName}}{{end}}:\n\n{{.Table}}{{range .Alerts}}{{if .AcknowledgeURL}}\n\nAcknowledge {{.AlertName}} for {{.InstanceName}}: {{.AcknowledgeURL}}{{end}}{{end}}"
+)
+
+var groupByKeys = map[string]func(alertLabels) string{
+	"org":      func(l alertLabels) string { return l.OrgGuid },
+	"space":    func(l alertLabels) string { return l.SpaceGuid },
+	"service":  func(l alertLabels) string { return l.Service },
+	"rule":     func(l alertLabels) string { return l.Rule },
+	"severity": func(l alertLabels) string { return l.Severity },
+}
+
+// groupingConfig batches firing alerts into groups with the same values for the GroupBy keys, like Alertmanager
+// does. A group is sent GroupWait after its first alert, then at most every GroupInterval when alerts were added or
+// removed and every RepeatInterval when nothing changed.
+type groupingConfig struct {
+	GroupBy        []string `json:"group_by"`
+	GroupWait      string   `json:"group_wait"`
+	GroupInterval  string   `json:"group_interval"`
+	RepeatInterval string   `json:"repeat_interval"`
+	Subject        string   `json:"subject"`
+	Message        string   `json:"message"`
+	Format         string   `json:"format"`
+
+	groupWait      time.Duration
+	groupInterval  time.Duration
+	repeatInterval time.Duration
+	subjectTmpl    *template.Template
+	messageTmpl    *template.Template
+}
+
+// digestConfig is a shorthand for grouping all alerts per space (or org) within a window.
+type digestConfig struct {
+	GroupBy string `json:"group_by"`
+	Window  string `json:"window"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+func (d digestConfig) grouping() (groupingConfig, error) {
+	groupBy := []string{"org"}
+	switch d.GroupBy {
+	case "org":
+	case "space":
+		groupBy = append(groupBy, "space")
+	default:
+		return groupingConfig{}, fmt.Errorf("digest group_by must be space or org")
+	}
+
+	return groupingConfig{
+		GroupBy:        groupBy,
+		GroupWait:      d.Window,
+		GroupInterval:  d.Window,
+		RepeatInterval: "24h",
+		Subject:        d.Subject,
+		Message:        d.Message,
+	}, nil
+}
+
+func (c *groupingConfig) parse() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	for _, key := range c.GroupBy {
+		if _, ok := groupByKeys[key]; !ok {
+			return fmt.Errorf("unknown grouping group_by key %q", key)
+		}
+	}
+	if !contains(c.GroupBy, "space") && !contains(c.GroupBy, "org") {
+		return fmt.Errorf("grouping group_by must include space or org")
+	}
+
+	var err error
+	for _, d := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"group_wait", c.GroupWait, &c.groupWait},
+		{"group_interval", c.GroupInterval, &c.groupInterval},
+		{"repeat_interval", c.RepeatInterval, &c.repeatInterval},
+	} {
+		if *d.dest, err = time.ParseDuration(d.value); err != nil {
+			return fmt.Errorf("invalid grouping %s: %v", d.name, err)
+		}
+	}
+
+	if err := validateFormat(c.Format); err != nil {
+		return fmt.Errorf("invalid grouping format: %v", err)
+	}
+
+	if c.Subject == "" {
+		c.Subject = defaultGroupSubject
+	}
+	if c.Message == "" {
+		c.Message = defaultGroupMessage
+	}
+	if c.subjectTmpl, err = template.New("groupSubject").Parse(c.Subject); err != nil {
+		return fmt.Errorf("invalid grouping subject: %v", err)
+	}
+	if c.messageTmpl, err = template.New("groupMessage").Parse(c.Message); err != nil {
+		return fmt.Errorf("invalid grouping message: %v", err)
+	}
+
+	return nil
+}
+
+func (c groupingConfig) Enabled() bool {
+	return len(c.GroupBy) > 0
+}
+
+// groupedAlert is a single alert in the group template data.
+type groupedAlert struct {
+	AlertName      string
+	InstanceId     string
+	InstanceName   string
+	Service        string
+	Severity       string
+	Subject        string
+	Message        string
+	StartsAt       time.Time
+	AcknowledgeURL string
+}
+
+type groupTemplateData struct {
+	EnvironmentName string
+	OrgName         string
+	SpaceName       string //empty when not grouping by space
+	GroupLabels     map[string]string
+	Alerts          []groupedAlert
+	Table           string //the alerts as a plain text table
+}
+
+type groupAlert struct {
+	msg      NotificationMessage
+	lastSeen time.Time
+}
+
+type notificationGroup struct {
+	key         string
+	groupLabels map[string]string
+	target      NotificationMessageTarget
+	sinks       []string
+	labels      alertLabels
+	created     time.Time
+	lastSent    time.Time
+	changed     bool
+	alerts      map[string]groupAlert //keyed by alert
+}
+
+// notificationGroups collects firing alerts per group and decides when to send each group.
+type notificationGroups struct {
+	mu     sync.Mutex
+	config groupingConfig
+	groups map[string]*notificationGroup
+}
+
+func NewNotificationGroups(config groupingConfig) *notificationGroups {
+	return &notificationGroups{
+		config: config,
+		groups: make(map[string]*notificationGroup),
+	}
+}
+
+func (g *notificationGroups) Enabled() bool {
+	return g.config.Enabled()
+}
+
+// Add adds a routed firing alert to its group, or marks it as still firing when it's already in there. Alerts are
+// only grouped with alerts routed to the same target and sinks, so routes and targets work as without grouping.
+func (g *notificationGroups) Add(d delivery, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	msg := d.msg
+	labels := alertLabels{Org: msg.Labels.Org, OrgGuid: msg.Labels.OrgGuid}
+	target := msg.Target
+	if contains(g.config.GroupBy, "space") {
+		labels.Space = msg.Labels.Space
+		labels.SpaceGuid = msg.Labels.SpaceGuid
+	} else if target.Type == "space" && target.Id == msg.Labels.SpaceGuid {
+		//groups of several spaces go to the org instead of the space of the service instance
+		target = NotificationMessageTarget{Type: "org", Environment: target.Environment, Id: msg.Labels.OrgGuid}
+	}
+
+	groupLabels := make(map[string]string)
+	var keyParts []string
+	for _, key := range g.config.GroupBy {
+		groupLabels[key] = groupByKeys[key](msg.Labels)
+		keyParts = append(keyParts, key+"="+groupLabels[key])
+	}
+	keyParts = append(keyParts, "target="+target.Type+"/"+target.Id+"/"+strings.Join(target.Roles, "+"), "sinks="+strings.Join(d.sinks, "+"))
+	key := strings.Join(keyParts, ",")
+
+	group, ok := g.groups[key]
+	if !ok {
+		group = &notificationGroup{
+			key:         key,
+			groupLabels: groupLabels,
+			target:      target,
+			sinks:       d.sinks,
+			labels:      labels,
+			created:     now,
+			alerts:      make(map[string]groupAlert),
+		}
+		g.groups[key] = group
+	}
+
+	alertKey := msg.Target.Id + "/" + msg.Labels.Key()
+	if _, ok := group.alerts[alertKey]; !ok {
+		group.changed = true
+	}
+	group.alerts[alertKey] = groupAlert{msg: msg, lastSeen: now}
+}
+
+// Flush drops alerts which weren't added again since scanStart (they resolved, or are silenced or inhibited now) and
+// returns a delivery for every group which is due.
+func (g *notificationGroups) Flush(environment string, scanStart, now time.Time) []delivery {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var deliveries []delivery
+	for key, group := range g.groups {
+		for alertKey, alert := range group.alerts {
+			if alert.lastSeen.Before(scanStart) {
+				delete(group.alerts, alertKey)
+				group.changed = true
+			}
+		}
+
+		if len(group.alerts) == 0 {
+			delete(g.groups, key)
+			continue
+		}
+
+		if !g.due(group, now) {
+			continue
+		}
+
+		msg, err := g.render(group, environment)
+		if err != nil {
+			log.Println("Error generating group notification: ", err)
+			continue
+		}
+
+		hash := sha1.Sum([]byte(group.key))
+		msg.Id = fmt.Sprintf("%s-%v", hex.EncodeToString(hash[:8]), now.Unix())
+		deliveries = append(deliveries, delivery{msg: msg, sinks: group.sinks})
+
+		group.lastSent = now
+		group.changed = false
+	}
+
+	return deliveries
+}
+
+func (g *notificationGroups) due(group *notificationGroup, now time.Time) bool {
+	switch {
+	case group.lastSent.IsZero():
+		return now.Sub(group.created) >= g.config.groupWait
+	case group.changed:
+		return now.Sub(group.lastSent) >= g.config.groupInterval
+	default:
+		return now.Sub(group.lastSent) >= g.config.repeatInterval
+	}
+}
+
+func (g *notificationGroups) render(group *notificationGroup, environment string) (NotificationMessage, error) {
+	data := groupTemplateData{
+		EnvironmentName: environment,
+		OrgName:         group.labels.Org,
+		SpaceName:       group.labels.Space,
+		GroupLabels:     group.groupLabels,
+	}
+
+	for _, alert := range group.alerts {
+		msg := alert.msg
+		data.Alerts = append(data.Alerts, groupedAlert{
+			AlertName:      msg.Labels.Rule,
+			InstanceId:     msg.Labels.InstanceGuid,
+			InstanceName:   msg.Labels.InstanceName,
+			Service:        msg.Labels.Service,
+			Severity:       msg.Labels.Severity,
+			Subject:        msg.Subject,
+			Message:        msg.Message,
+			StartsAt:       msg.StartsAt,
+			AcknowledgeURL: msg.AcknowledgeURL,
+		})
+	}
+	sort.Slice(data.Alerts, func(i, j int) bool {
+		if data.Alerts[i].InstanceName != data.Alerts[j].InstanceName {
+			return data.Alerts[i].InstanceName < data.Alerts[j].InstanceName
+		}
+		return data.Alerts[i].AlertName < data.Alerts[j].AlertName
+	})
+	data.Table = alertTable(data.Alerts)
+
+	var subject, message bytes.Buffer
+	if err := g.config.subjectTmpl.Execute(&subject, data); err != nil {
+		return NotificationMessage{}, fmt.Errorf("Error rendering group subject: %v", err)
+	}
+	if err := g.config.messageTmpl.Execute(&message, g.messageData(data)); err != nil {
+		return NotificationMessage{}, fmt.Errorf("Error rendering group message: %v", err)
+	}
+
+	return NotificationMessage{
+		Subject:  subject.String(),
+		Message:  message.String(),
+		Target:   group.target,
+		Status:   statusFiring,
+		Labels:   group.labels,
+		StartsAt: group.created,
+		Format:   g.config.Format,
+	}, nil
+}
+
+// messageData returns the data for the message template. Markdown messages get the names tenants choose and the
+// subjects of the alerts, which contain those, escaped.
+func (g *notificationGroups) messageData(data groupTemplateData) groupTemplateData {
+	if g.config.Format != formatMarkdown {
+		return data
+	}
+
+	data.OrgName = escapeMarkdown(data.OrgName)
+	data.SpaceName = escapeMarkdown(data.SpaceName)
+	alerts := make([]groupedAlert, len(data.Alerts))
+	for i, alert := range data.Alerts {
+		alert.InstanceName = escapeMarkdown(alert.InstanceName)
+		alert.Subject = escapeMarkdown(alert.Subject)
+		alerts[i] = alert
+	}
+	data.Alerts = alerts
+
+	return data
+}
+
+func alertTable(alerts []groupedAlert) string {
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE INSTANCE\tSERVICE\tALERT\tSEVERITY\tFIRING SINCE")
+	for _, alert := range alerts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", alert.InstanceName, alert.Service, alert.AlertName, alert.Severity, alert.StartsAt.Format(time.RFC3339))
+	}
+	w.Flush()
+
+	return strings.TrimRight(table.String(), "\n")
+}
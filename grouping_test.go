@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestGroupingKeepsRoutesAndTargetsApart(t *testing.T) {
+	config := groupingConfig{GroupBy: []string{"org"}, GroupWait: "30s", GroupInterval: "5m", RepeatInterval: "4h"}
+	if err := config.parse(); err != nil {
+		t.Fatal(err)
+	}
+	g := NewNotificationGroups(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	alert := func(instance, space string, target NotificationMessageTarget) NotificationMessage {
+		msg := testMessage()
+		msg.Labels.InstanceGuid = instance
+		msg.Labels.SpaceGuid = space
+		msg.Labels.OrgGuid = "org-guid"
+		msg.Target = target
+		return msg
+	}
+	ownSpace := func(space string) NotificationMessageTarget {
+		return NotificationMessageTarget{Type: "space", Environment: "test", Id: space}
+	}
+	team := NotificationMessageTarget{Type: "team", Environment: "test", Id: "dba"}
+
+	//two alerts of the same org routed to the same sink, which go to the org as they are in different spaces
+	g.Add(delivery{msg: alert("guid-1", "space-1", ownSpace("space-1")), sinks: []string{"slack"}}, now)
+	g.Add(delivery{msg: alert("guid-2", "space-2", ownSpace("space-2")), sinks: []string{"slack"}}, now)
+	//routed to another sink
+	g.Add(delivery{msg: alert("guid-3", "space-1", ownSpace("space-1")), sinks: []string{"pagerduty"}}, now)
+	//addressed to another target
+	g.Add(delivery{msg: alert("guid-4", "space-1", team), sinks: []string{"slack"}}, now)
+
+	if deliveries := g.Flush("test", now, now.Add(10*time.Second)); len(deliveries) != 0 {
+		t.Fatalf("groups were sent before group_wait: %+v", deliveries)
+	}
+
+	deliveries := g.Flush("test", now, now.Add(30*time.Second))
+	if len(deliveries) != 3 {
+		t.Fatalf("got %d groups, want 3", len(deliveries))
+	}
+
+	groups := map[string]delivery{}
+	for _, d := range deliveries {
+		groups[d.sinks[0]+" "+d.msg.Target.Type] = d
+	}
+	if d, ok := groups["slack org"]; !ok || d.msg.Target.Id != "org-guid" {
+		t.Errorf("alerts of several spaces should go to the org, got %+v", deliveries)
+	}
+	if _, ok := groups["pagerduty org"]; !ok {
+		t.Errorf("missing group routed to pagerduty, got %+v", deliveries)
+	}
+	if d, ok := groups["slack team"]; !ok || d.msg.Target.Id != "dba" {
+		t.Errorf("missing group for the team, got %+v", deliveries)
+	}
+
+	//nothing changed, so nothing is sent until repeat_interval
+	later := now.Add(time.Hour)
+	g.Add(delivery{msg: alert("guid-1", "space-1", ownSpace("space-1")), sinks: []string{"slack"}}, later)
+	g.Add(delivery{msg: alert("guid-2", "space-2", ownSpace("space-2")), sinks: []string{"slack"}}, later)
+	if deliveries := g.Flush("test", later, later); len(deliveries) != 0 {
+		t.Errorf("unchanged groups were sent again: %+v", deliveries)
+	}
+}
+
+func TestGroupingSendsSingleAlertsToPerAlertSinks(t *testing.T) {
+	config := groupingConfig{GroupBy: []string{"space"}, GroupWait: "0s", GroupInterval: "5m", RepeatInterval: "4h"}
+	if err := config.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	sink := func(name string, perAlert, refreshing bool) namedNotifier {
+		return namedNotifier{name: name, id: name, perAlert: perAlert, refreshing: refreshing, notifier: funcNotifier(func(msg NotificationMessage) error {
+			kind := "alert"
+			if msg.Refresh {
+				kind = "refresh"
+			} else if msg.Labels.InstanceGuid == "" {
+				kind = "group"
+			}
+			sent = append(sent, name+":"+kind)
+			return nil
+		})}
+	}
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &alertServer{
+		outbox:      newTestOutbox(t, notifiers{sink("slack", false, false), sink("pagerduty", true, false), sink("alertmanager", true, true)}),
+		rateLimiter: NewRateLimiter(rateLimitsConfig{}),
+		groups:      NewNotificationGroups(config),
+		alerts:      NewAlertStates(store, "0", flapDetection{}),
+	}
+
+	msg := testMessage()
+	state := alertState{Labels: msg.Labels, Firing: true, FiringSince: msg.StartsAt}
+	rule := alertRule{Name: "disk", Subject: "Disk almost full", Message: "Disk is full"}
+	info := instanceInfo{
+		Instance: cfclient.V3ServiceInstance{Guid: "guid-1", Name: "my_db"},
+		Space:    &cfclient.V3Space{Name: "space"},
+		Org:      &cfclient.V3Organization{Name: "org"},
+	}
+	now := msg.StartsAt
+
+	a.notifyGrouped(info, rule, state, 0, []NotificationMessage{msg}, true, now)
+	a.sendGroups(now)
+	if got := strings.Join(sent, ","); got != "pagerduty:alert,alertmanager:alert,slack:group" {
+		t.Errorf("first scan sent %s", got)
+	}
+
+	//until the next notification is due the alert stays in its group and is refreshed in Alertmanager
+	sent = nil
+	a.notifyGrouped(info, rule, state, 0, []NotificationMessage{msg}, false, now.Add(time.Minute))
+	a.sendGroups(now.Add(time.Minute))
+	if got := strings.Join(sent, ","); got != "alertmanager:refresh" {
+		t.Errorf("next scan sent %s", got)
+	}
+}
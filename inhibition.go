@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// inhibitRule suppresses alerts matching the target rule while an alert matching the source rule is firing for the
+// same service instance. Both are regular expressions matched against the full rule name.
+type inhibitRule struct {
+	SourceRule string `json:"source_rule"`
+	TargetRule string `json:"target_rule"`
+
+	source *regexp.Regexp
+	target *regexp.Regexp
+}
+
+func (r *inhibitRule) parse() error {
+	var err error
+	if r.source, err = regexp.Compile("^(?:" + r.SourceRule + ")$"); err != nil {
+		return fmt.Errorf("invalid inhibit rule source_rule %q: %v", r.SourceRule, err)
+	}
+	if r.target, err = regexp.Compile("^(?:" + r.TargetRule + ")$"); err != nil {
+		return fmt.Errorf("invalid inhibit rule target_rule %q: %v", r.TargetRule, err)
+	}
+
+	return nil
+}
+
+// inhibitingAlert returns the firing alert that inhibits target, or nil. An alert never inhibits itself.
+func inhibitingAlert(rules []inhibitRule, target firingAlert, firing []firingAlert) *firingAlert {
+	for _, r := range rules {
+		if !r.target.MatchString(target.rule.Name) {
+			continue
+		}
+
+		for i := range firing {
+			if firing[i].rule.Name != target.rule.Name && r.source.MatchString(firing[i].rule.Name) {
+				return &firing[i]
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInhibitingAlert(t *testing.T) {
+	rules := []inhibitRule{
+		{SourceRule: "Instance down", TargetRule: ".*"},
+		{SourceRule: "Disk full", TargetRule: "Disk (usage|growth)"},
+	}
+	for i := range rules {
+		if err := rules[i].parse(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alert := func(name string) firingAlert {
+		return firingAlert{rule: alertRule{Name: name}}
+	}
+
+	for _, test := range []struct {
+		name       string
+		target     string
+		firing     []string
+		wantSource string
+	}{
+		{"inhibited by a matching source", "Disk usage", []string{"Disk usage", "Disk full"}, "Disk full"},
+		{"inhibited by a wildcard", "Memory usage", []string{"Memory usage", "Instance down"}, "Instance down"},
+		{"no source firing", "Disk usage", []string{"Disk usage", "Memory usage"}, ""},
+		{"not a target", "Memory usage", []string{"Memory usage", "Disk full"}, ""},
+		{"never inhibits itself", "Instance down", []string{"Instance down"}, ""},
+		{"the full name must match", "Disk usage high", []string{"Disk usage high", "Disk full"}, ""},
+	} {
+		var firing []firingAlert
+		for _, name := range test.firing {
+			firing = append(firing, alert(name))
+		}
+
+		source := inhibitingAlert(rules, alert(test.target), firing)
+		if (source == nil && test.wantSource != "") || (source != nil && source.rule.Name != test.wantSource) {
+			t.Errorf("%s: inhibited by %v, want %q", test.name, source, test.wantSource)
+		}
+	}
+}
+
+func TestInvalidInhibitRule(t *testing.T) {
+	r := inhibitRule{SourceRule: "(", TargetRule: ".*"}
+	if err := r.parse(); err == nil {
+		t.Errorf("invalid source_rule was accepted")
+	}
+}
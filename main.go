@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"time"
 
 	"github.com/cloudfoundry-community/go-cfclient"
 	"github.com/cloudfoundry-community/go-cfenv"
@@ -39,17 +40,82 @@ func main() {
 		log.Fatal("Error creating Prometheus client", err)
 	}
 
+	notifierConfigs, err := config.notifierConfigs()
+	if err != nil {
+		log.Fatal("Error loading notifiers: ", err)
+	}
+
+	sinks, err := NewNotifiers(notifierConfigs, cfClient)
+	if err != nil {
+		log.Fatal("Error creating notifiers: ", err)
+	}
+	logNotifiers(sinks)
+
+	if err := validateSinkNames(rules, sinks); err != nil {
+		log.Fatal("Error in rules: ", err)
+	}
+
+	//without STATE_PATH state is kept on the local disk of the instance, which isn't shared with the other instances
+	statePath := config.StatePath
+	if statePath == "" {
+		statePath = "state"
+	}
+	store, err := NewFileStateStore(statePath)
+	if err != nil {
+		log.Fatal("Error creating state store", err)
+	}
+
+	node := strconv.Itoa(appEnv.Index)
+
 	as := &alertServer{
-		cfClient:                  cfClient,
-		promClient:                promClient,
-		appGuid:                   appEnv.AppID,
-		node:                      strconv.Itoa(appEnv.Index),
-		alertRules:                rules,
-		environment:               config.Environment,
-		notificationSerivceClient: *NewNotificationServiceClient(config.NotificationServiceUrl, config.NotificationServiceUser, config.NotificationServicePassword),
+		cfClient:             cfClient,
+		promClient:           promClient,
+		appGuid:              appEnv.AppID,
+		node:                 node,
+		sharedState:          config.StatePath != "",
+		store:                store,
+		alertRules:           rules.Rules,
+		environment:          config.Environment,
+		outbox:               NewOutbox(sinks, store, node, config.outboxConfig()),
+		rateLimiter:          NewRateLimiter(rules.RateLimits),
+		groups:               NewNotificationGroups(rules.Grouping),
+		alerts:               NewAlertStates(store, node, rules.FlapDetection),
+		silences:             NewSilenceStore(store),
+		acknowledgements:     NewAcknowledgementStore(store),
+		quietHours:           rules.QuietHours,
+		inhibitRules:         rules.InhibitRules,
+		routes:               rules.Routes,
+		flapDetection:        rules.FlapDetection,
+		alertmanagerReceiver: rules.AlertmanagerReceiver,
+		operationGracePeriod: time.Second * time.Duration(config.OperationGracePeriod),
+	}
+
+	if config.AckSecret != "" {
+		baseURL := config.ExternalURL
+		if baseURL == "" && len(appEnv.ApplicationURIs) > 0 {
+			baseURL = "https://" + appEnv.ApplicationURIs[0]
+		}
+		if baseURL == "" {
+			log.Fatal("Please set EXTERNAL_URL or map a route to use acknowledgement links")
+		}
+		as.ackLinks = NewAckLinks(config.AckSecret, baseURL, time.Second*time.Duration(config.AckLinkValidity))
+	}
+
+	if app, err := cfClient.GetAppByGuid(appEnv.AppID); err == nil {
+		as.setNodes(app.Instances)
+	}
+
+	if (config.ApiUser != "" && config.ApiPassword != "") || as.ackLinks != nil {
+		if !as.sharedState && as.nodeCount() > 1 {
+			log.Println("STATE_PATH not set while running more than one instance. Silences and acknowledgements are disabled.")
+		}
+		as.StartApi(config.Port, config.ApiUser, config.ApiPassword)
+	} else {
+		log.Println("API_USER/API_PASSWORD not set. The API is disabled.")
 	}
 
 	as.Start(int64(config.CheckInterval))
+	as.outbox.Start(15 * time.Second)
 
 	signals := make(chan os.Signal, 2)
 	signal.Notify(signals, os.Interrupt)
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A small Markdown renderer for message bodies. It supports headings, paragraphs, bulleted and numbered lists,
+// fenced code blocks, inline bold, italic, code and links and backslash escapes, which is what fits in a notification.
+
+const (
+	formatPlain    = "plain"
+	formatMarkdown = "markdown"
+)
+
+type mdBlock struct {
+	kind  string //heading, paragraph, list, numbered or code
+	level int    //heading level
+	lines []string
+}
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumbered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+
+	mdCodeSpan = regexp.MustCompile("`([^`]+)`")
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalic   = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+
+	mdEscaped = regexp.MustCompile("\\\\([\\\\`*_\\[\\]()#+\\-.!|])")
+
+	//inline rendering marks bold with \x00, links with \x01 and escaped characters with \x02, so those are dropped
+	//from the input
+	mdPlaceholderChars   = strings.NewReplacer("\x00", "", "\x01", "", "\x02", "")
+	mdLinkPlaceholder    = regexp.MustCompile("\x01[0-9]+\x01")
+	mdEscapedPlaceholder = regexp.MustCompile("\x02[0-9]+\x02")
+
+	mdSpecialChars = strings.NewReplacer("\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+		"#", "\\#", "+", "\\+", "-", "\\-", ".", "\\.", "!", "\\!", "|", "\\|", "\r\n", " ", "\n", " ")
+)
+
+// escapeMarkdown escapes text so it shows up as is in a Markdown message, for values tenants control like the
+// names of service instances.
+func escapeMarkdown(text string) string {
+	return mdSpecialChars.Replace(text)
+}
+
+func parseMarkdown(src string) []mdBlock {
+	var blocks []mdBlock
+	var current *mdBlock
+
+	flush := func() {
+		if current != nil {
+			blocks = append(blocks, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		if current != nil && current.kind == "code" {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				flush()
+			} else {
+				current.lines = append(current.lines, line)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "```"):
+			flush()
+			current = &mdBlock{kind: "code"}
+		case strings.TrimSpace(line) == "":
+			flush()
+		case mdHeading.MatchString(line):
+			flush()
+			m := mdHeading.FindStringSubmatch(line)
+			blocks = append(blocks, mdBlock{kind: "heading", level: len(m[1]), lines: []string{m[2]}})
+		case mdBullet.MatchString(line):
+			if current == nil || current.kind != "list" {
+				flush()
+				current = &mdBlock{kind: "list"}
+			}
+			current.lines = append(current.lines, mdBullet.FindStringSubmatch(line)[1])
+		case mdNumbered.MatchString(line):
+			if current == nil || current.kind != "numbered" {
+				flush()
+				current = &mdBlock{kind: "numbered"}
+			}
+			current.lines = append(current.lines, mdNumbered.FindStringSubmatch(line)[1])
+		default:
+			if current == nil || current.kind != "paragraph" {
+				flush()
+				current = &mdBlock{kind: "paragraph"}
+			}
+			current.lines = append(current.lines, strings.TrimSpace(line))
+		}
+	}
+	flush()
+
+	return blocks
+}
+
+// inlineStyle renders the inline formatting for an output format.
+type inlineStyle struct {
+	escape  func(text string) string
+	code    func(text string) string
+	link    func(text, url string) string
+	bold    func(text string) string
+	italic  func(text string) string
+	literal func(char string) string //renders a backslash escaped character, escape when nil
+}
+
+// render applies the inline formatting to text, leaving code spans and escaped characters alone.
+func (style inlineStyle) render(text string) string {
+	var literals []string
+	text = mdEscaped.ReplaceAllStringFunc(mdPlaceholderChars.Replace(text), func(s string) string {
+		literals = append(literals, s[1:])
+		return fmt.Sprintf("\x02%d\x02", len(literals)-1)
+	})
+
+	var out strings.Builder
+	last := 0
+	for _, m := range mdCodeSpan.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(style.renderEmphasis(text[last:m[0]]))
+		out.WriteString(style.code(style.escape(text[m[2]:m[3]])))
+		last = m[1]
+	}
+	out.WriteString(style.renderEmphasis(text[last:]))
+
+	literal := style.literal
+	if literal == nil {
+		literal = style.escape
+	}
+	return mdEscapedPlaceholder.ReplaceAllStringFunc(out.String(), func(s string) string {
+		i, _ := strconv.Atoi(strings.Trim(s, "\x02"))
+		return literal(literals[i])
+	})
+}
+
+// renderEmphasis renders links, bold and italic. Links are replaced by placeholders while rendering bold and italic,
+// so those are never applied inside a url.
+func (style inlineStyle) renderEmphasis(text string) string {
+	var links []string
+	text = mdLink.ReplaceAllStringFunc(style.escape(text), func(s string) string {
+		m := mdLink.FindStringSubmatch(s)
+		links = append(links, style.link(style.emphasis(m[1]), m[2]))
+		return fmt.Sprintf("\x01%d\x01", len(links)-1)
+	})
+
+	return mdLinkPlaceholder.ReplaceAllStringFunc(style.emphasis(text), func(s string) string {
+		i, _ := strconv.Atoi(strings.Trim(s, "\x01"))
+		return links[i]
+	})
+}
+
+func (style inlineStyle) emphasis(text string) string {
+	text = mdBold.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdBold.FindStringSubmatch(s)
+		return style.bold(m[1] + m[2])
+	})
+
+	return mdItalic.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdItalic.FindStringSubmatch(s)
+		return style.italic(m[1] + m[2])
+	})
+}
+
+func identity(s string) string {
+	return s
+}
+
+// markdownToHTML renders Markdown as HTML, for email.
+func markdownToHTML(src string) string {
+	inline := inlineStyle{
+		escape: html.EscapeString,
+		code:   func(s string) string { return "<code>" + s + "</code>" },
+		link:   func(text, url string) string { return fmt.Sprintf(`<a href="%s">%s</a>`, url, text) },
+		bold:   func(s string) string { return "<strong>" + s + "</strong>" },
+		italic: func(s string) string { return "<em>" + s + "</em>" },
+	}.render
+
+	var out strings.Builder
+	for _, block := range parseMarkdown(src) {
+		switch block.kind {
+		case "heading":
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", block.level, inline(block.lines[0]), block.level)
+		case "list", "numbered":
+			tag := "ul"
+			if block.kind == "numbered" {
+				tag = "ol"
+			}
+			fmt.Fprintf(&out, "<%s>\n", tag)
+			for _, item := range block.lines {
+				fmt.Fprintf(&out, "<li>%s</li>\n", inline(item))
+			}
+			fmt.Fprintf(&out, "</%s>\n", tag)
+		case "code":
+			fmt.Fprintf(&out, "<pre><code>%s</code></pre>\n", html.EscapeString(strings.Join(block.lines, "\n")))
+		default:
+			fmt.Fprintf(&out, "<p>%s</p>\n", inline(strings.Join(block.lines, "\n")))
+		}
+	}
+
+	return out.String()
+}
+
+// markdownToSlack renders Markdown as Slack mrkdwn.
+func markdownToSlack(src string) string {
+	style := inlineStyle{
+		escape: escapeSlack,
+		code:   func(s string) string { return "`" + s + "`" },
+		link:   func(text, url string) string { return "<" + url + "|" + text + ">" },
+		//bold is marked with a placeholder first, as Slack uses single asterisks for it and those would be taken for italic
+		bold:   func(s string) string { return "\x00" + s + "\x00" },
+		italic: func(s string) string { return "_" + s + "_" },
+	}
+	inline := func(text string) string {
+		return strings.ReplaceAll(style.render(text), "\x00", "*")
+	}
+
+	var blocks []string
+	for _, block := range parseMarkdown(src) {
+		switch block.kind {
+		case "heading":
+			blocks = append(blocks, "*"+strings.ReplaceAll(inline(block.lines[0]), "*", "")+"*")
+		case "list", "numbered":
+			var items []string
+			for i, item := range block.lines {
+				bullet := "•"
+				if block.kind == "numbered" {
+					bullet = fmt.Sprintf("%d.", i+1)
+				}
+				items = append(items, bullet+" "+inline(item))
+			}
+			blocks = append(blocks, strings.Join(items, "\n"))
+		case "code":
+			blocks = append(blocks, "```\n"+escapeSlack(strings.Join(block.lines, "\n"))+"\n```")
+		default:
+			blocks = append(blocks, inline(strings.Join(block.lines, "\n")))
+		}
+	}
+
+	return strings.Join(blocks, "\n\n")
+}
+
+// markdownToPlain renders Markdown as plain text, for channels without formatting.
+func markdownToPlain(src string) string {
+	inline := inlineStyle{
+		escape: identity,
+		code:   identity,
+		link: func(text, url string) string {
+			if text == url {
+				return text
+			}
+			return text + " (" + url + ")"
+		},
+		bold:   identity,
+		italic: identity,
+	}.render
+
+	var blocks []string
+	for _, block := range parseMarkdown(src) {
+		switch block.kind {
+		case "heading":
+			blocks = append(blocks, inline(block.lines[0]))
+		case "list", "numbered":
+			var items []string
+			for i, item := range block.lines {
+				bullet := "-"
+				if block.kind == "numbered" {
+					bullet = fmt.Sprintf("%d.", i+1)
+				}
+				items = append(items, bullet+" "+inline(item))
+			}
+			blocks = append(blocks, strings.Join(items, "\n"))
+		case "code":
+			blocks = append(blocks, strings.Join(block.lines, "\n"))
+		default:
+			blocks = append(blocks, inline(strings.Join(block.lines, "\n")))
+		}
+	}
+
+	return strings.Join(blocks, "\n\n")
+}
+
+func validateFormat(format string) error {
+	if format != "" && format != formatPlain && format != formatMarkdown {
+		return fmt.Errorf("format must be plain or markdown")
+	}
+
+	return nil
+}
+
+func formatOrPlain(format string) string {
+	if format == "" {
+		return formatPlain
+	}
+
+	return format
+}
+
+// PlainMessage returns the message body as plain text.
+func (msg NotificationMessage) PlainMessage() string {
+	if msg.Format == formatMarkdown {
+		return markdownToPlain(msg.Message)
+	}
+
+	return msg.Message
+}
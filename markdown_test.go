@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		src       string
+		wantHTML  string
+		wantSlack string
+		wantPlain string
+	}{
+		{
+			name:      "emphasis",
+			src:       "Disk is **full**, _really_ *full* and `df -h` says so",
+			wantHTML:  "<p>Disk is <strong>full</strong>, <em>really</em> <em>full</em> and <code>df -h</code> says so</p>\n",
+			wantSlack: "Disk is *full*, _really_ _full_ and `df -h` says so",
+			wantPlain: "Disk is full, really full and df -h says so",
+		},
+		{
+			name:      "no emphasis in code",
+			src:       "run `rm *.log` and `my_db_name`",
+			wantHTML:  "<p>run <code>rm *.log</code> and <code>my_db_name</code></p>\n",
+			wantSlack: "run `rm *.log` and `my_db_name`",
+			wantPlain: "run rm *.log and my_db_name",
+		},
+		{
+			name:      "no emphasis in link urls",
+			src:       "see [the **dashboard**](https://example.com/my_dash_board/*x*) and [logs](https://example.com/__logs__)",
+			wantHTML:  "<p>see <a href=\"https://example.com/my_dash_board/*x*\">the <strong>dashboard</strong></a> and <a href=\"https://example.com/__logs__\">logs</a></p>\n",
+			wantSlack: "see <https://example.com/my_dash_board/*x*|the *dashboard*> and <https://example.com/__logs__|logs>",
+			wantPlain: "see the dashboard (https://example.com/my_dash_board/*x*) and logs (https://example.com/__logs__)",
+		},
+		{
+			name:      "underscores within words",
+			src:       "instance my_db_name",
+			wantHTML:  "<p>instance my_db_name</p>\n",
+			wantSlack: "instance my_db_name",
+			wantPlain: "instance my_db_name",
+		},
+		{
+			name:      "escaping",
+			src:       "a < b & c > d",
+			wantHTML:  "<p>a &lt; b &amp; c &gt; d</p>\n",
+			wantSlack: "a &lt; b &amp; c &gt; d",
+			wantPlain: "a < b & c > d",
+		},
+		{
+			name:      "backslash escapes",
+			src:       "\\*not italic\\*, \\_not\\_ \\[a link\\](b) and `a\\*b`",
+			wantHTML:  "<p>*not italic*, _not_ [a link](b) and <code>a*b</code></p>\n",
+			wantSlack: "*not italic*, _not_ [a link](b) and `a*b`",
+			wantPlain: "*not italic*, _not_ [a link](b) and a*b",
+		},
+		{
+			name:      "placeholders in the input",
+			src:       "a\x010\x01 [b](c) \x020\x02 **d\x00**",
+			wantHTML:  "<p>a0 <a href=\"c\">b</a> 0 <strong>d</strong></p>\n",
+			wantSlack: "a0 <c|b> 0 *d*",
+			wantPlain: "a0 b (c) 0 d",
+		},
+		{
+			name:      "blocks",
+			src:       "# Disk **full**\n\n- one\n* two\n\n1. first\n2) second\n\n```\n<df> -h\n```\nlast\nline",
+			wantHTML:  "<h1>Disk <strong>full</strong></h1>\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n<pre><code>&lt;df&gt; -h</code></pre>\n<p>last\nline</p>\n",
+			wantSlack: "*Disk full*\n\n• one\n• two\n\n1. first\n2. second\n\n```\n&lt;df&gt; -h\n```\n\nlast\nline",
+			wantPlain: "Disk full\n\n- one\n- two\n\n1. first\n2. second\n\n<df> -h\n\nlast\nline",
+		},
+	} {
+		if got := markdownToHTML(test.src); got != test.wantHTML {
+			t.Errorf("%s: markdownToHTML = %q, want %q", test.name, got, test.wantHTML)
+		}
+		if got := markdownToSlack(test.src); got != test.wantSlack {
+			t.Errorf("%s: markdownToSlack = %q, want %q", test.name, got, test.wantSlack)
+		}
+		if got := markdownToPlain(test.src); got != test.wantPlain {
+			t.Errorf("%s: markdownToPlain = %q, want %q", test.name, got, test.wantPlain)
+		}
+	}
+}
+
+func TestPlainMessage(t *testing.T) {
+	msg := testMessage()
+	if got := msg.PlainMessage(); got != msg.Message {
+		t.Errorf("plain messages are not converted, got %q", got)
+	}
+
+	msg.Format = formatMarkdown
+	if got := msg.PlainMessage(); got != "Disk of my_db is 90% full" {
+		t.Errorf("PlainMessage = %q", got)
+	}
+}
+
+func TestTenantNamesAreEscapedInMarkdown(t *testing.T) {
+	data := messageTemplateData{InstanceName: "*my_db* [x](https://example.com)", SpaceName: "# space", BoundApps: []string{"app_1_"}}
+	rule := alertRule{Subject: "{{.InstanceName}}", Message: "Service **{{.InstanceName}}** in {{.SpaceName}} for {{index .BoundApps 0}}", Format: formatMarkdown}
+
+	subject, message, err := rule.render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != data.InstanceName {
+		t.Errorf("subject = %q, subjects are not Markdown", subject)
+	}
+	if got := markdownToHTML(message); got != "<p>Service <strong>*my_db* [x](https://example.com)</strong> in # space for app_1_</p>\n" {
+		t.Errorf("rendered %q", got)
+	}
+
+	//Teams TextBlocks are Markdown themselves, so the names stay escaped
+	card := adaptiveCardBody(NotificationMessage{Message: message, Format: formatMarkdown})
+	if !strings.HasPrefix(card[0].Text, `Service **\*my\_db\* \[x\]\(https://example\.com\)**`) {
+		t.Errorf("card text = %q", card[0].Text)
+	}
+
+	rule.Format = formatPlain
+	if _, message, _ := rule.render(data); message != "Service **"+data.InstanceName+"** in # space for app_1_" {
+		t.Errorf("plain message = %q", message)
+	}
+}
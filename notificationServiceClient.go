@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +11,9 @@ import (
 	"time"
 )
 
+// errAlreadySent is returned when cfNotificationService already sent a message with the same id within its validity.
+var errAlreadySent = errors.New("Message was sent before. It won't be sent again")
+
 type NotificationServiceClient struct {
 	url        *url.URL
 	httpClient http.Client
@@ -23,12 +27,31 @@ type NotificationMessage struct {
 	Message   string                    `json:"message"`
 	ExpiresIn string                    `json:"validity,omitempty"`
 	Target    NotificationMessageTarget `json:"target"`
+
+	//not sent to cfNotificationService, used by the other notifiers
+	Status   string      `json:"-"`
+	Labels   alertLabels `json:"-"`
+	StartsAt time.Time   `json:"-"`
+	EndsAt   time.Time   `json:"-"`
+	Targets  []target    `json:"-"` //the targets chosen by the rule, routes can override them
+
+	AcknowledgeURL string `json:"-"`
+	Format         string `json:"-"` //markdown, or plain when empty
+	Chart          []byte `json:"-"` //PNG chart of the metric
+	Refresh        bool   `json:"-"` //only for the notifiers which need firing alerts on every check
 }
 
+const (
+	statusFiring   = "firing"
+	statusFlapping = "flapping"
+	statusResolved = "resolved"
+)
+
 type NotificationMessageTarget struct {
-	Type        string `json:"type"`
-	Environment string `json:"environment,omitempty"`
-	Id          string `json:"id"`
+	Type        string   `json:"type"`
+	Environment string   `json:"environment,omitempty"`
+	Id          string   `json:"id"`
+	Roles       []string `json:"roles,omitempty"`
 }
 
 func NewNotificationServiceClient(urlString, username, password string) *NotificationServiceClient {
@@ -48,6 +71,7 @@ func NewNotificationServiceClient(urlString, username, password string) *Notific
 }
 
 func (c *NotificationServiceClient) Send(msg NotificationMessage) error {
+	msg.Message = msg.PlainMessage()
 	msgBody, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("Error unmarshalling message to json: %v", err)
@@ -59,14 +83,17 @@ func (c *NotificationServiceClient) Send(msg NotificationMessage) error {
 	if err != nil {
 		return fmt.Errorf("Error calling notification Service: %v", err.Error())
 	}
+	resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return fmt.Errorf("Unable to login to notification service")
-	case http.StatusConflict:
-		return fmt.Errorf("Message was sent before. It won't be sent again")
-	case http.StatusOK:
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return permanentError{fmt.Errorf("Unable to login to notification service")}
+	case resp.StatusCode == http.StatusConflict:
+		return errAlreadySent
+	case resp.StatusCode == http.StatusOK:
 		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return permanentError{fmt.Errorf("Notification service return status code: %v", resp.StatusCode)}
 	default:
 		return fmt.Errorf("Notification service return status code: %v", resp.StatusCode)
 	}
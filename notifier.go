@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// notifier delivers a notification to one sink (cfNotificationService, a webhook, email, chat, ...).
+type notifier interface {
+	Send(msg NotificationMessage) error
+}
+
+type namedNotifier struct {
+	name         string //the configured name, which routes and escalations refer to
+	id           string //identifies the sink in the outbox: the name, or name#i for each url of a webhook with several urls
+	sendResolved bool
+	refreshing   bool //firing alerts expire in the sink unless they are sent again on every check
+	perAlert     bool //the sink tracks alerts by their labels, so it only takes notifications about a single alert
+	notifier
+}
+
+// wants reports whether the sink should receive msg.
+func (n namedNotifier) wants(msg NotificationMessage) bool {
+	if n.perAlert && msg.Labels.InstanceGuid == "" {
+		//group notifications and summaries of suppressed notifications have no alert key
+		return false
+	}
+	if msg.Refresh {
+		return n.refreshing
+	}
+
+	return msg.Status != statusResolved || n.sendResolved
+}
+
+// routedTo reports whether sinkNames (all sinks when nil) includes the sink.
+func (n namedNotifier) routedTo(sinkNames []string) bool {
+	return sinkNames == nil || contains(sinkNames, n.name)
+}
+
+type notifiers []namedNotifier
+
+// refreshing reports whether any of the sinks needs firing alerts on every check.
+func (n notifiers) refreshing() bool {
+	for _, sink := range n {
+		if sink.refreshing {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wanted reports whether any of the sinks in sinkNames (all sinks when nil) wants msg.
+func (n notifiers) wanted(msg NotificationMessage, sinkNames []string) bool {
+	for _, sink := range n {
+		if sink.routedTo(sinkNames) && sink.wants(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitPerAlert splits sinkNames (all sinks when nil) into the sinks which track single alerts and the others.
+func (n notifiers) splitPerAlert(sinkNames []string) (perAlert []string, others []string) {
+	seen := make(map[string]bool)
+	for _, sink := range n {
+		if !sink.routedTo(sinkNames) || seen[sink.name] {
+			continue
+		}
+		seen[sink.name] = true
+
+		if sink.perAlert {
+			perAlert = append(perAlert, sink.name)
+		} else {
+			others = append(others, sink.name)
+		}
+	}
+
+	return perAlert, others
+}
+
+// permanentError is returned by notifiers for failures which won't go away by trying again, like a rejected
+// request or invalid credentials. Other errors are retried.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// sendErrors are the errors of delivering a notification to several sinks. errors.Is and errors.As match each of
+// them, so callers can still tell a notification which was sent before or is rate limited.
+type sendErrors []error
+
+func (e sendErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e sendErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e sendErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifierConfig configures a single sink. Which fields are used depends on the type.
+type notifierConfig struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	URL        string            `json:"url"`
+	URLs       []string          `json:"urls"`
+	Secret     string            `json:"secret"`
+	Username   string            `json:"username"`
+	Password   string            `json:"password"`
+	Headers    map[string]string `json:"headers"`
+	Host       string            `json:"host"`
+	Port       int               `json:"port"`
+	From       string            `json:"from"`
+	To         []string          `json:"to"`
+	Roles      []string          `json:"roles"`
+	RoutingKey string            `json:"routing_key"`
+	ApiKey     string            `json:"api_key"`
+	//SendResolved defaults to true for alertmanager and false for all other types
+	SendResolved *bool `json:"send_resolved"`
+}
+
+// notifiersConfigLoad reads the sinks from a json file. Environment variables in the file ($VAR or ${VAR}) are
+// expanded so secrets don't have to be stored in the file.
+func notifiersConfigLoad(path string) ([]notifierConfig, error) {
+	inBuf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []notifierConfig
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(inBuf))), &configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+func NewNotifiers(configs []notifierConfig, cfClient *cfclient.Client) (notifiers, error) {
+	var sinks notifiers
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("%s-%v", config.Type, i)
+		}
+
+		sendResolved := config.Type == "alertmanager"
+		if config.SendResolved != nil {
+			sendResolved = *config.SendResolved
+		}
+		named := namedNotifier{
+			name:         config.Name,
+			id:           config.Name,
+			sendResolved: sendResolved,
+			refreshing:   config.Type == "alertmanager",
+			perAlert:     config.Type == "pagerduty" || config.Type == "opsgenie" || config.Type == "alertmanager",
+		}
+
+		if config.Type == "webhook" {
+			webhooks, err := NewWebhookNotifiers(config)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %s: %v", config.Name, err)
+			}
+			for j, webhook := range webhooks {
+				if len(webhooks) > 1 {
+					named.id = fmt.Sprintf("%s#%v", config.Name, j)
+				}
+				named.notifier = webhook
+				sinks = append(sinks, named)
+			}
+			continue
+		}
+
+		sink, err := NewNotifier(config, cfClient)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %v", config.Name, err)
+		}
+		named.notifier = sink
+		sinks = append(sinks, named)
+	}
+
+	return sinks, nil
+}
+
+func NewNotifier(config notifierConfig, cfClient *cfclient.Client) (notifier, error) {
+	switch config.Type {
+	case "cfnotificationservice":
+		return NewNotificationServiceClient(config.URL, config.Username, config.Password), nil
+	case "smtp":
+		return NewSmtpNotifier(config, cfClient)
+	case "slack":
+		return NewSlackNotifier(config)
+	case "teams":
+		return NewTeamsNotifier(config)
+	case "pagerduty":
+		return NewPagerDutyNotifier(config)
+	case "opsgenie":
+		return NewOpsgenieNotifier(config)
+	case "alertmanager":
+		return NewAlertmanagerNotifier(config)
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", config.Type)
+	}
+}
+
+func newNotifierHttpClient() http.Client {
+	return http.Client{
+		Timeout: 15 * time.Second,
+	}
+}
+
+// postJSON posts body as json and treats any non 2xx response as an error.
+func postJSON(client http.Client, url string, headers map[string]string, body interface{}) error {
+	msgBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Error marshalling message to json: %v", err)
+	}
+
+	return postBody(client, url, headers, msgBody)
+}
+
+func postBody(client http.Client, url string, headers map[string]string, msgBody []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(msgBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error calling %s: %v", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{fmt.Errorf("%s returned status code: %v", req.URL.Host, resp.StatusCode)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned status code: %v", req.URL.Host, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func requireFields(fields map[string]string) error {
+	for name, value := range fields {
+		if value == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+
+	return nil
+}
+
+func logNotifiers(sinks notifiers) {
+	for _, sink := range sinks {
+		log.Printf("Sending notifications to %s\n", sink.id)
+	}
+}
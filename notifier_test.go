@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedRequest is a request captured by a stand-in server.
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// standIn is a local HTTP server standing in for a sink. It records every request and responds with status.
+type standIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	received []receivedRequest
+}
+
+func newStandIn(t *testing.T, status int) *standIn {
+	s := &standIn{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.received = append(s.received, receivedRequest{method: r.Method, path: r.URL.RequestURI(), header: r.Header, body: body})
+		s.mu.Unlock()
+
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *standIn) requests() []receivedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]receivedRequest{}, s.received...)
+}
+
+func testMessage() NotificationMessage {
+	return NotificationMessage{
+		Id:      "guid-1-rule",
+		Subject: "Disk almost full",
+		Message: "Disk of my_db is **90%** full",
+		Target:  NotificationMessageTarget{Type: "space", Environment: "test", Id: "space-guid"},
+		Status:  statusFiring,
+		Labels: alertLabels{
+			Org:          "org",
+			Space:        "space",
+			SpaceGuid:    "space-guid",
+			InstanceGuid: "guid-1",
+			InstanceName: "my_db",
+			Service:      "postgres",
+			Rule:         "disk",
+			Severity:     "critical",
+		},
+		StartsAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotificationServiceClientErrors(t *testing.T) {
+	for _, test := range []struct {
+		status        int
+		wantErr       bool
+		wantPermanent bool
+		wantSent      bool
+	}{
+		{http.StatusOK, false, false, false},
+		{http.StatusConflict, true, false, true},
+		{http.StatusUnauthorized, true, true, false},
+		{http.StatusBadRequest, true, true, false},
+		{http.StatusBadGateway, true, false, false},
+	} {
+		server := newStandIn(t, test.status)
+		c := NewNotificationServiceClient(server.URL, "user", "pass")
+
+		err := c.Send(testMessage())
+		if (err != nil) != test.wantErr || isPermanent(err) != test.wantPermanent || errors.Is(err, errAlreadySent) != test.wantSent {
+			t.Errorf("status %d: got %v", test.status, err)
+		}
+
+		req := server.requests()[0]
+		if req.path != "/send" {
+			t.Errorf("posted to %s, want /send", req.path)
+		}
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(req.body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg["status"]; ok {
+			t.Errorf("internal fields must not be sent to cfNotificationService: %s", req.body)
+		}
+		if msg["message"] != "Disk of my_db is **90%** full" {
+			t.Errorf("message = %v", msg["message"])
+		}
+	}
+}
+
+func TestPostBodyErrors(t *testing.T) {
+	for _, test := range []struct {
+		status        int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{http.StatusAccepted, false, false},
+		{http.StatusNotFound, true, true},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusInternalServerError, true, false},
+	} {
+		server := newStandIn(t, test.status)
+
+		err := postBody(newNotifierHttpClient(), server.URL, nil, []byte("{}"))
+		if (err != nil) != test.wantErr || isPermanent(err) != test.wantPermanent {
+			t.Errorf("status %d: got %v", test.status, err)
+		}
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	server := newStandIn(t, http.StatusAccepted)
+	n, _ := NewPagerDutyNotifier(notifierConfig{URL: server.URL, RoutingKey: "key"})
+
+	resolved := testMessage()
+	resolved.Status = statusResolved
+	for _, msg := range []NotificationMessage{testMessage(), resolved} {
+		if err := n.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events []map[string]interface{}
+	for _, req := range server.requests() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(req.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	if events[0]["event_action"] != "trigger" || events[1]["event_action"] != "resolve" {
+		t.Errorf("event actions = %v, %v", events[0]["event_action"], events[1]["event_action"])
+	}
+	if events[0]["dedup_key"] != "guid-1/disk" || events[1]["dedup_key"] != "guid-1/disk" {
+		t.Errorf("dedup keys = %v, %v", events[0]["dedup_key"], events[1]["dedup_key"])
+	}
+	if severity := events[0]["payload"].(map[string]interface{})["severity"]; severity != "critical" {
+		t.Errorf("severity = %v", severity)
+	}
+}
+
+func TestOpsgenie(t *testing.T) {
+	server := newStandIn(t, http.StatusAccepted)
+	n, _ := NewOpsgenieNotifier(notifierConfig{URL: server.URL, ApiKey: "key"})
+
+	resolved := testMessage()
+	resolved.Status = statusResolved
+	for _, msg := range []NotificationMessage{testMessage(), resolved} {
+		if err := n.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := server.requests()
+	if requests[0].path != "/v2/alerts" || requests[0].header.Get("Authorization") != "GenieKey key" {
+		t.Errorf("create: %s %v", requests[0].path, requests[0].header)
+	}
+	if !strings.HasPrefix(requests[1].path, "/v2/alerts/guid-1%2Fdisk/close?identifierType=alias") {
+		t.Errorf("close: %s", requests[1].path)
+	}
+	if !strings.Contains(string(requests[0].body), `"priority":"P1"`) {
+		t.Errorf("create: %s", requests[0].body)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, _ := NewSlackNotifier(notifierConfig{URL: server.URL})
+
+	if err := n.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct{ Text string }
+	if err := json.Unmarshal(server.requests()[0].body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "*Disk almost full*\nDisk of my_db is **90%** full" {
+		t.Errorf("text = %q", body.Text)
+	}
+}
+
+func TestSlackEscapesPlainMessages(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, _ := NewSlackNotifier(notifierConfig{URL: server.URL})
+
+	msg := testMessage()
+	msg.Subject = "Disk of <my_db> almost full"
+	msg.Message = "Disk of <!channel> & <my_db> is full"
+	if err := n.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct{ Text string }
+	if err := json.Unmarshal(server.requests()[0].body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "*Disk of &lt;my_db&gt; almost full*\nDisk of &lt;!channel&gt; &amp; &lt;my_db&gt; is full" {
+		t.Errorf("text = %q", body.Text)
+	}
+}
+
+func TestSlackMarkdown(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, _ := NewSlackNotifier(notifierConfig{URL: server.URL})
+
+	msg := testMessage()
+	msg.Format = formatMarkdown
+	if err := n.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct{ Text string }
+	if err := json.Unmarshal(server.requests()[0].body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "*Disk almost full*\nDisk of my_db is *90%* full" {
+		t.Errorf("text = %q", body.Text)
+	}
+}
+
+func TestTeamsAdaptiveCard(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	n, _ := NewTeamsNotifier(notifierConfig{URL: server.URL})
+
+	plain := testMessage()
+	markdown := testMessage()
+	markdown.Format = formatMarkdown
+	markdown.Message = "# Details\n\nDisk is **full**\n\n- one\n- two\n\n```\ndf -h\n```"
+	markdown.AcknowledgeURL = "https://alerts.example.com/acknowledge?token=t"
+
+	for _, test := range []struct {
+		name     string
+		msg      NotificationMessage
+		wantBody []adaptiveCardElement
+	}{
+		{"plain", plain, []adaptiveCardElement{literalText("Disk of my_db is **90%** full")}},
+		{"markdown", markdown, []adaptiveCardElement{
+			{Type: "TextBlock", Text: "Details", Wrap: true, Weight: "Bolder"},
+			{Type: "TextBlock", Text: "Disk is **full**", Wrap: true},
+			{Type: "TextBlock", Text: "- one\r- two", Wrap: true},
+			{Type: "RichTextBlock", Inlines: []adaptiveCardElement{{Type: "TextRun", Text: "df -h", FontType: "Monospace"}}},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := n.Send(test.msg); err != nil {
+				t.Fatal(err)
+			}
+			requests := server.requests()
+
+			var message teamsMessage
+			if err := json.Unmarshal(requests[len(requests)-1].body, &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type != "message" || len(message.Attachments) != 1 || message.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+				t.Fatalf("unexpected message %+v", message)
+			}
+
+			card := message.Attachments[0].Content
+			if card.Type != "AdaptiveCard" || len(card.Body) == 0 {
+				t.Fatalf("unexpected card %+v", card)
+			}
+			title := card.Body[0].Inlines[0]
+			if title.Text != "Disk almost full" || title.Color != "Attention" {
+				t.Errorf("title = %+v", title)
+			}
+
+			got, _ := json.Marshal(card.Body[1:])
+			want, _ := json.Marshal(test.wantBody)
+			if string(got) != string(want) {
+				t.Errorf("body = %s\nwant %s", got, want)
+			}
+
+			if (len(card.Actions) == 1) != (test.msg.AcknowledgeURL != "") {
+				t.Errorf("actions = %+v", card.Actions)
+			}
+		})
+	}
+}
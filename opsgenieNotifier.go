@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const opsgenieURL = "https://api.opsgenie.com"
+
+type opsgenieNotifier struct {
+	url        string
+	apiKey     string
+	httpClient http.Client
+}
+
+func NewOpsgenieNotifier(config notifierConfig) (*opsgenieNotifier, error) {
+	if err := requireFields(map[string]string{"api_key": config.ApiKey}); err != nil {
+		return nil, err
+	}
+
+	apiURL := config.URL
+	if apiURL == "" {
+		apiURL = opsgenieURL
+	}
+
+	return &opsgenieNotifier{
+		url:        strings.TrimSuffix(apiURL, "/"),
+		apiKey:     config.ApiKey,
+		httpClient: newNotifierHttpClient(),
+	}, nil
+}
+
+// Send creates an Opsgenie alert. The alert key is used as alias so Opsgenie deduplicates repeated notifications.
+func (n *opsgenieNotifier) Send(msg NotificationMessage) error {
+	headers := map[string]string{"Authorization": "GenieKey " + n.apiKey}
+
+	if msg.Status == statusResolved {
+		return postJSON(n.httpClient, n.url+"/v2/alerts/"+url.PathEscape(msg.Labels.Key())+"/close?identifierType=alias", headers, struct {
+			Source string `json:"source"`
+		}{Source: "cfServiceAlert"})
+	}
+
+	return postJSON(n.httpClient, n.url+"/v2/alerts", headers, struct {
+		Message     string            `json:"message"`
+		Alias       string            `json:"alias"`
+		Description string            `json:"description"`
+		Priority    string            `json:"priority"`
+		Source      string            `json:"source"`
+		Tags        []string          `json:"tags"`
+		Details     map[string]string `json:"details"`
+	}{
+		Message:     truncate(msg.Subject, 130),
+		Alias:       msg.Labels.Key(),
+		Description: truncate(msg.PlainMessage(), 15000),
+		Priority:    opsgeniePriority(msg.Labels.Severity),
+		Source:      "cfServiceAlert",
+		Tags:        []string{msg.Labels.Service, msg.Labels.Severity},
+		Details: map[string]string{
+			"org":                   msg.Labels.Org,
+			"space":                 msg.Labels.Space,
+			"service_instance_guid": msg.Labels.InstanceGuid,
+			"service_instance_name": msg.Labels.InstanceName,
+			"plan":                  msg.Labels.Plan,
+			"rule":                  msg.Labels.Rule,
+		},
+	})
+}
+
+func opsgeniePriority(severity string) string {
+	switch severity {
+	case "critical":
+		return "P1"
+	case "error":
+		return "P2"
+	case "info":
+		return "P5"
+	default:
+		return "P3"
+	}
+}
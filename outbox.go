@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// outboxMessage is a NotificationMessage including the fields which are not sent to cfNotificationService, so
+// queued messages survive a restart completely.
+type outboxMessage struct {
+	NotificationMessage
+	Status   string      `json:"status"`
+	Labels   alertLabels `json:"labels"`
+	StartsAt time.Time   `json:"starts_at"`
+	EndsAt   time.Time   `json:"ends_at"`
+
+	AcknowledgeURL string `json:"acknowledge_url,omitempty"`
+	Format         string `json:"format,omitempty"`
+	Chart          []byte `json:"chart,omitempty"`
+}
+
+func newOutboxMessage(msg NotificationMessage) outboxMessage {
+	return outboxMessage{
+		NotificationMessage: msg,
+		Status:              msg.Status,
+		Labels:              msg.Labels,
+		StartsAt:            msg.StartsAt,
+		EndsAt:              msg.EndsAt,
+		AcknowledgeURL:      msg.AcknowledgeURL,
+		Format:              msg.Format,
+		Chart:               msg.Chart,
+	}
+}
+
+func (m outboxMessage) Message() NotificationMessage {
+	msg := m.NotificationMessage
+	msg.Status = m.Status
+	msg.Labels = m.Labels
+	msg.StartsAt = m.StartsAt
+	msg.EndsAt = m.EndsAt
+	msg.AcknowledgeURL = m.AcknowledgeURL
+	msg.Format = m.Format
+	msg.Chart = m.Chart
+
+	return msg
+}
+
+type outboxEntry struct {
+	Sink         string        `json:"sink"`
+	Message      outboxMessage `json:"message"`
+	Attempts     int           `json:"attempts"`
+	FirstAttempt time.Time     `json:"first_attempt"`
+	NextAttempt  time.Time     `json:"next_attempt"`
+	LastError    string        `json:"last_error"`
+
+	replaced bool //a newer version of the message was queued while the entry was being sent
+}
+
+func (e outboxEntry) key() string {
+	return e.Sink + "/" + e.Message.Id
+}
+
+type outboxConfig struct {
+	RetryInitial  time.Duration
+	RetryMax      time.Duration
+	MaxAge        time.Duration
+	MaxDeadLetter int
+}
+
+// outbox delivers notifications to all sinks. Deliveries failing with a retryable error are persisted and retried
+// with exponential backoff and jitter until they succeed or get older than MaxAge. Those, and deliveries failing
+// with a permanent error, end up in the dead letter store.
+type outbox struct {
+	mu          sync.Mutex
+	sinks       notifiers
+	store       stateStore
+	config      outboxConfig
+	name        string
+	deadName    string
+	entries     map[string]outboxEntry
+	deadLetters []outboxEntry
+	sending     map[string]bool //keys of the entries being sent, these are sent without holding mu
+}
+
+func NewOutbox(sinks notifiers, store stateStore, node string, config outboxConfig) *outbox {
+	o := &outbox{
+		sinks:    sinks,
+		store:    store,
+		config:   config,
+		name:     "outbox-" + node,
+		deadName: "deadletters-" + node,
+		entries:  make(map[string]outboxEntry),
+		sending:  make(map[string]bool),
+	}
+
+	if err := store.Load(o.name, &o.entries); err != nil {
+		log.Printf("Unable to load outbox, starting empty: %v\n", err)
+	}
+	if err := store.Load(o.deadName, &o.deadLetters); err != nil {
+		log.Printf("Unable to load dead letters, starting empty: %v\n", err)
+	}
+
+	return o
+}
+
+// Send delivers msg to every sink in sinkNames (all sinks when nil) that wants it. Failed deliveries which will be
+// retried don't count as an error.
+func (o *outbox) Send(msg NotificationMessage, sinkNames []string) error {
+	now := time.Now()
+	var due []outboxEntry
+
+	o.mu.Lock()
+	for _, sink := range o.sinks {
+		if !sink.routedTo(sinkNames) || !sink.wants(msg) {
+			continue
+		}
+
+		entry := outboxEntry{Sink: sink.id, Message: newOutboxMessage(msg), FirstAttempt: now}
+		if queued, ok := o.entries[entry.key()]; ok {
+			//the same notification is still waiting for a retry, send the latest version of it when it's due
+			queued.Message = entry.Message
+			queued.replaced = true
+			o.entries[entry.key()] = queued
+			continue
+		}
+		if o.sending[entry.key()] {
+			//the same notification is being delivered right now
+			continue
+		}
+
+		o.sending[entry.key()] = true
+		due = append(due, entry)
+	}
+	o.mu.Unlock()
+
+	if errs := o.attempt(due, now); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// attempt sends the entries, which must be marked as sending, without holding the lock so a slow sink doesn't block
+// other notifications. Afterwards it queues or dead letters the failed entries. It returns the errors of the
+// notifications which won't be retried.
+func (o *outbox) attempt(entries []outboxEntry, now time.Time) sendErrors {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	results := make([]error, len(entries))
+	for i := range entries {
+		entries[i].Attempts++
+		sink, _ := o.sink(entries[i].Sink)
+		results[i] = sink.Send(entries[i].Message.Message())
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var errs sendErrors
+	for i, entry := range entries {
+		delete(o.sending, entry.key())
+		if err := o.result(entry, results[i], now); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Sink, err))
+		}
+	}
+	o.save()
+
+	return errs
+}
+
+// result records the outcome of sending entry. It returns an error only when the notification won't be retried.
+func (o *outbox) result(entry outboxEntry, err error, now time.Time) error {
+	if queued, ok := o.entries[entry.key()]; ok && queued.replaced {
+		//a newer version of the notification arrived while sending, deliver that one on the next retry
+		entry.Message = queued.Message
+		if err == nil {
+			entry.NextAttempt = now
+			o.entries[entry.key()] = entry
+			return nil
+		}
+	}
+
+	if err == nil {
+		delete(o.entries, entry.key())
+		return nil
+	}
+
+	entry.LastError = err.Error()
+
+	switch {
+	case errors.Is(err, errAlreadySent):
+		delete(o.entries, entry.key())
+		return err
+	case isPermanent(err):
+		log.Printf("Delivery of %s to %s failed permanently: %v\n", entry.Message.Id, entry.Sink, err)
+		o.deadLetter(entry)
+		return err
+	case now.Sub(entry.FirstAttempt) >= o.config.MaxAge:
+		log.Printf("Giving up delivery of %s to %s after %v attempts: %v\n", entry.Message.Id, entry.Sink, entry.Attempts, err)
+		o.deadLetter(entry)
+		return err
+	default:
+		entry.NextAttempt = now.Add(o.backoff(entry.Attempts))
+		log.Printf("Delivery of %s to %s failed, retrying at %v: %v\n", entry.Message.Id, entry.Sink, entry.NextAttempt.Format(time.RFC3339), err)
+		o.entries[entry.key()] = entry
+		return nil
+	}
+}
+
+// backoff doubles the delay for every attempt up to RetryMax and picks a random delay between half and the full
+// delay, so queued notifications don't all hit a recovering sink at the same time.
+func (o *outbox) backoff(attempts int) time.Duration {
+	delay := o.config.RetryInitial
+	for i := 1; i < attempts && delay < o.config.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > o.config.RetryMax {
+		delay = o.config.RetryMax
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (o *outbox) deadLetter(entry outboxEntry) {
+	delete(o.entries, entry.key())
+
+	o.deadLetters = append(o.deadLetters, entry)
+	if len(o.deadLetters) > o.config.MaxDeadLetter {
+		o.deadLetters = o.deadLetters[len(o.deadLetters)-o.config.MaxDeadLetter:]
+	}
+}
+
+// Start retries due notifications every interval.
+func (o *outbox) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			o.retry()
+		}
+	}()
+}
+
+func (o *outbox) retry() {
+	now := time.Now()
+	var due []outboxEntry
+
+	o.mu.Lock()
+	deadLettered := false
+	for key, entry := range o.entries {
+		if now.Before(entry.NextAttempt) || o.sending[key] {
+			continue
+		}
+
+		if _, ok := o.sink(entry.Sink); !ok {
+			entry.LastError = "notifier is no longer configured"
+			o.deadLetter(entry)
+			deadLettered = true
+			continue
+		}
+
+		entry.replaced = false
+		o.entries[key] = entry
+		o.sending[key] = true
+		due = append(due, entry)
+	}
+	if deadLettered && len(due) == 0 {
+		o.save()
+	}
+	o.mu.Unlock()
+
+	o.attempt(due, now)
+}
+
+func (o *outbox) sink(id string) (namedNotifier, bool) {
+	for _, sink := range o.sinks {
+		if sink.id == id {
+			return sink, true
+		}
+	}
+
+	return namedNotifier{}, false
+}
+
+func (o *outbox) Entries() []outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := make([]outboxEntry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func (o *outbox) DeadLetters() []outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]outboxEntry{}, o.deadLetters...)
+}
+
+func (o *outbox) save() {
+	if err := o.store.Save(o.name, o.entries); err != nil {
+		log.Printf("Unable to save outbox: %v\n", err)
+	}
+	if err := o.store.Save(o.deadName, o.deadLetters); err != nil {
+		log.Printf("Unable to save dead letters: %v\n", err)
+	}
+}
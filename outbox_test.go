@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// funcNotifier is a sink that calls send.
+type funcNotifier func(msg NotificationMessage) error
+
+func (f funcNotifier) Send(msg NotificationMessage) error {
+	return f(msg)
+}
+
+func newTestOutbox(t *testing.T, sinks notifiers) *outbox {
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewOutbox(sinks, store, "0", outboxConfig{RetryInitial: time.Minute, RetryMax: time.Hour, MaxAge: 24 * time.Hour, MaxDeadLetter: 10})
+}
+
+func TestOutboxDeliveries(t *testing.T) {
+	o := newTestOutbox(t, notifiers{
+		{name: "ok", id: "ok", notifier: funcNotifier(func(NotificationMessage) error { return nil })},
+		{name: "down", id: "down", notifier: funcNotifier(func(NotificationMessage) error { return fmt.Errorf("connection refused") })},
+		{name: "broken", id: "broken", notifier: funcNotifier(func(NotificationMessage) error { return permanentError{fmt.Errorf("bad request")} })},
+	})
+
+	err := o.Send(testMessage(), nil)
+	if err == nil || err.Error() != "broken: bad request" {
+		t.Errorf("only the permanent failure should be returned, got %v", err)
+	}
+
+	entries := o.Entries()
+	if len(entries) != 1 || entries[0].Sink != "down" || entries[0].Attempts != 1 || entries[0].LastError != "connection refused" {
+		t.Errorf("unexpected outbox %+v", entries)
+	}
+	if !entries[0].NextAttempt.After(time.Now().Add(29 * time.Second)) {
+		t.Errorf("retry at %v is not backed off", entries[0].NextAttempt)
+	}
+
+	deadLetters := o.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Sink != "broken" {
+		t.Errorf("unexpected dead letters %+v", deadLetters)
+	}
+}
+
+func TestOutboxOnlySendsToRoutedSinks(t *testing.T) {
+	var sent []string
+	sink := func(name string) namedNotifier {
+		return namedNotifier{name: name, id: name, notifier: funcNotifier(func(NotificationMessage) error {
+			sent = append(sent, name)
+			return nil
+		})}
+	}
+	o := newTestOutbox(t, notifiers{sink("a"), sink("b"), sink("c")})
+
+	if err := o.Send(testMessage(), []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0] != "b" {
+		t.Errorf("sent to %v, want [b]", sent)
+	}
+}
+
+func TestOutboxDoesNotLockWhileSending(t *testing.T) {
+	sending := make(chan bool)
+	release := make(chan bool)
+	o := newTestOutbox(t, notifiers{{name: "slow", id: "slow", notifier: funcNotifier(func(NotificationMessage) error {
+		sending <- true
+		<-release
+		return errors.New("timeout")
+	})}})
+
+	done := make(chan error)
+	go func() { done <- o.Send(testMessage(), nil) }()
+	<-sending
+
+	entriesRead := make(chan []outboxEntry)
+	go func() { entriesRead <- o.Entries() }()
+	select {
+	case <-entriesRead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Entries blocked while a sink was sending")
+	}
+
+	//the same notification isn't sent twice at the same time
+	if err := o.Send(testMessage(), nil); err != nil {
+		t.Errorf("got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("a retryable error should not be returned, got %v", err)
+	}
+	if entries := o.Entries(); len(entries) != 1 {
+		t.Errorf("got %d outbox entries, want 1", len(entries))
+	}
+}
+
+func TestOutboxErrorsCanBeMatched(t *testing.T) {
+	o := newTestOutbox(t, notifiers{
+		{name: "cfns", id: "cfns", notifier: funcNotifier(func(NotificationMessage) error { return errAlreadySent })},
+		{name: "broken", id: "broken", notifier: funcNotifier(func(NotificationMessage) error { return permanentError{fmt.Errorf("bad request")} })},
+	})
+
+	err := o.Send(testMessage(), nil)
+	if !errors.Is(err, errAlreadySent) || !isPermanent(err) || errors.Is(err, errRateLimited) {
+		t.Errorf("errors of all sinks should match, got %v", err)
+	}
+	if !notified(err) || notified(errors.New("connection refused")) || !notified(sendErrors{errRateLimited}) {
+		t.Errorf("a notification sent before or rate limited counts as notified")
+	}
+}
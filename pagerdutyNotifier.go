@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	httpClient http.Client
+}
+
+func NewPagerDutyNotifier(config notifierConfig) (*pagerDutyNotifier, error) {
+	if err := requireFields(map[string]string{"routing_key": config.RoutingKey}); err != nil {
+		return nil, err
+	}
+
+	url := config.URL
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+
+	return &pagerDutyNotifier{
+		url:        url,
+		routingKey: config.RoutingKey,
+		httpClient: newNotifierHttpClient(),
+	}, nil
+}
+
+// Send triggers a PagerDuty Events v2 event. The alert key is used as dedup key so repeated notifications for the
+// same alert end up in the same incident.
+func (n *pagerDutyNotifier) Send(msg NotificationMessage) error {
+	type payload struct {
+		Summary       string      `json:"summary"`
+		Source        string      `json:"source"`
+		Severity      string      `json:"severity"`
+		Component     string      `json:"component"`
+		Group         string      `json:"group"`
+		CustomDetails interface{} `json:"custom_details"`
+	}
+
+	eventAction := "trigger"
+	if msg.Status == statusResolved {
+		eventAction = "resolve"
+	}
+
+	return postJSON(n.httpClient, n.url, nil, struct {
+		RoutingKey  string  `json:"routing_key"`
+		EventAction string  `json:"event_action"`
+		DedupKey    string  `json:"dedup_key"`
+		Payload     payload `json:"payload"`
+	}{
+		RoutingKey:  n.routingKey,
+		EventAction: eventAction,
+		DedupKey:    msg.Labels.Key(),
+		Payload: payload{
+			Summary:   truncate(msg.Subject, 1024),
+			Source:    msg.Labels.InstanceGuid,
+			Severity:  pagerDutySeverity(msg.Labels.Severity),
+			Component: msg.Labels.Service,
+			Group:     msg.Labels.Org + "/" + msg.Labels.Space,
+			CustomDetails: map[string]interface{}{
+				"message": msg.PlainMessage(),
+				"labels":  msg.Labels,
+			},
+		},
+	})
+}
+
+func pagerDutySeverity(severity string) string {
+	switch severity {
+	case "critical", "error", "warning", "info":
+		return severity
+	default:
+		return "warning"
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	return s[:max]
+}
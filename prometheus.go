@@ -45,6 +45,19 @@ func (p *PrometheusClient) Query(q string) (model.Value, error) {
 	return result, nil
 }
 
+func (p *PrometheusClient) QueryRange(q string, start, end time.Time, step time.Duration) (model.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, warnings, err := p.api.QueryRange(ctx, q, v1.Range{Start: start, End: end, Step: step})
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		fmt.Printf("Warnings: %v\n", warnings)
+	}
+	return result, nil
+}
+
 type ApiKeyAuthRoundTripper struct {
 	apiKey string
 	rt     http.RoundTripper
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	quietHoursSuppress = "suppress"
+	quietHoursDefer    = "defer"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// timeRange is a daily time range on the given weekdays. When end is before start the range continues past midnight
+// into the next day.
+type timeRange struct {
+	Weekdays []string `json:"weekdays"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+
+	days  map[time.Weekday]bool
+	start int //minutes since midnight
+	end   int
+}
+
+func (r *timeRange) parse() error {
+	r.days = make(map[time.Weekday]bool)
+	for _, day := range r.Weekdays {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", day)
+		}
+		r.days[weekday] = true
+	}
+
+	var err error
+	if r.start, err = parseClock(r.Start, 0); err != nil {
+		return err
+	}
+	if r.end, err = parseClock(r.End, 24*60); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseClock(clock string, defaultMinutes int) (int, error) {
+	if clock == "" {
+		return defaultMinutes, nil
+	}
+
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r timeRange) onDay(day time.Weekday) bool {
+	return len(r.days) == 0 || r.days[day]
+}
+
+func (r timeRange) Contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+
+	if r.start < r.end {
+		return r.onDay(t.Weekday()) && minutes >= r.start && minutes < r.end
+	}
+
+	//range wraps around midnight
+	return (r.onDay(t.Weekday()) && minutes >= r.start) ||
+		(r.onDay(t.AddDate(0, 0, -1).Weekday()) && minutes < r.end)
+}
+
+// quietHours is a recurring window during which notifications are suppressed or deferred. It applies to rules that
+// reference it by name and to every rule for the listed orgs.
+type quietHours struct {
+	Name       string      `json:"name"`
+	Timezone   string      `json:"timezone"`
+	Times      []timeRange `json:"times"`
+	Severities []string    `json:"severities"`
+	Orgs       []string    `json:"orgs"`
+	Action     string      `json:"action"`
+
+	location *time.Location
+}
+
+func (q *quietHours) parse() error {
+	if q.Name == "" {
+		return fmt.Errorf("quiet hours without a name")
+	}
+
+	var err error
+	if q.location, err = time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("quiet hours %s: %v", q.Name, err)
+	}
+
+	if len(q.Times) == 0 {
+		return fmt.Errorf("quiet hours %s: no times configured", q.Name)
+	}
+	for i := range q.Times {
+		if err := q.Times[i].parse(); err != nil {
+			return fmt.Errorf("quiet hours %s: %v", q.Name, err)
+		}
+	}
+
+	switch q.Action {
+	case "":
+		q.Action = quietHoursSuppress
+	case quietHoursSuppress, quietHoursDefer:
+	default:
+		return fmt.Errorf("quiet hours %s: action must be %s or %s", q.Name, quietHoursSuppress, quietHoursDefer)
+	}
+
+	return nil
+}
+
+func (q quietHours) AppliesTo(rule alertRule, labels alertLabels) bool {
+	if len(q.Severities) > 0 && !contains(q.Severities, labels.Severity) {
+		return false
+	}
+
+	return contains(rule.QuietHours, q.Name) || contains(q.Orgs, labels.Org)
+}
+
+func (q quietHours) Active(now time.Time) bool {
+	local := now.In(q.location)
+	for _, r := range q.Times {
+		if r.Contains(local) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// activeQuietHours returns the first quiet hours window that is active for the alert, or nil.
+func activeQuietHours(windows []quietHours, rule alertRule, labels alertLabels, now time.Time) *quietHours {
+	for _, q := range windows {
+		if q.AppliesTo(rule, labels) && q.Active(now) {
+			return &q
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
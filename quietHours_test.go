@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRangeContains(t *testing.T) {
+	//2024-05-03 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		name  string
+		r     timeRange
+		t     time.Time
+		wants bool
+	}{
+		{"within", timeRange{Start: "09:00", End: "17:00"}, at(3, 12, 0), true},
+		{"at start", timeRange{Start: "09:00", End: "17:00"}, at(3, 9, 0), true},
+		{"at end", timeRange{Start: "09:00", End: "17:00"}, at(3, 17, 0), false},
+		{"whole day", timeRange{Weekdays: []string{"sat", "sun"}}, at(4, 23, 59), true},
+		{"other day", timeRange{Weekdays: []string{"sat", "sun"}}, at(3, 12, 0), false},
+		{"before midnight", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(3, 23, 0), true},
+		{"after midnight", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 5, 59), true},
+		{"after the wrapped end", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 6, 0), false},
+		{"before start", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(3, 21, 59), false},
+		{"early on the weekday itself", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(3, 3, 0), false},
+		{"late on the next day", timeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 23, 0), false},
+		{"every night", timeRange{Start: "22:00", End: "06:00"}, at(1, 2, 0), true},
+	} {
+		if err := test.r.parse(); err != nil {
+			t.Fatal(err)
+		}
+		if got := test.r.Contains(test.t); got != test.wants {
+			t.Errorf("%s: Contains(%v) = %v, want %v", test.name, test.t, got, test.wants)
+		}
+	}
+}
+
+func TestQuietHoursUsesTimezone(t *testing.T) {
+	q := quietHours{Name: "night", Timezone: "Europe/Amsterdam", Times: []timeRange{{Start: "22:00", End: "06:00"}}}
+	if err := q.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	//21:00 UTC is 23:00 in Amsterdam in summer
+	if !q.Active(time.Date(2024, 5, 3, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("quiet hours should be active at 23:00 local time")
+	}
+	if q.Active(time.Date(2024, 5, 3, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("quiet hours should not be active at 06:00 local time")
+	}
+}
+
+func TestSuppressOnlyAlertsStartingInQuietHours(t *testing.T) {
+	q := quietHours{Name: "night", Timezone: "UTC", Times: []timeRange{{Start: "22:00", End: "06:00"}}}
+	if err := q.parse(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &alertServer{alerts: NewAlertStates(store, "0", flapDetection{}), quietHours: []quietHours{q}}
+
+	night := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	rule := alertRule{Name: "disk", QuietHours: []string{"night"}}
+	for _, test := range []struct {
+		name           string
+		firingSince    time.Time
+		wantSuppressed bool
+	}{
+		{"started during quiet hours", night.Add(-time.Hour), true},
+		{"started before quiet hours", night.Add(-2 * time.Hour), false},
+	} {
+		labels := testMessage().Labels
+		state := alertState{Labels: labels, Firing: true, FiringSince: test.firingSince, LastNotified: test.firingSince}
+		a.notify(instanceInfo{}, firingAlert{rule: rule, state: state}, night)
+
+		for _, s := range a.alerts.List() {
+			if s.Suppressed != test.wantSuppressed {
+				t.Errorf("%s: suppressed = %v, want %v", test.name, s.Suppressed, test.wantSuppressed)
+			}
+		}
+	}
+}
+
+func TestExampleQuietHoursApply(t *testing.T) {
+	rules, err := rulesConfigLoad("rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := rules.Rules["redis"][0]
+	labels := alertLabels{Org: "org", Severity: rule.SeverityOrDefault()}
+	for _, q := range rules.QuietHours {
+		if !q.AppliesTo(rule, labels) {
+			t.Errorf("example quiet hours %s apply to no rule", q.Name)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit allows Limit notifications per Per, with bursts up to Limit.
+type rateLimit struct {
+	Limit int    `json:"limit"`
+	Per   string `json:"per"`
+
+	per time.Duration
+}
+
+func (l *rateLimit) parse(name string) error {
+	if l.Limit == 0 {
+		return nil
+	}
+
+	var err error
+	if l.per, err = time.ParseDuration(l.Per); err != nil {
+		return fmt.Errorf("invalid rate_limits %s per: %v", name, err)
+	}
+
+	return nil
+}
+
+type rateLimitsConfig struct {
+	Space  rateLimit `json:"space"`
+	Org    rateLimit `json:"org"`
+	Global rateLimit `json:"global"`
+}
+
+func (c *rateLimitsConfig) parse() error {
+	if err := c.Space.parse("space"); err != nil {
+		return err
+	}
+	if err := c.Org.parse("org"); err != nil {
+		return err
+	}
+
+	return c.Global.parse("global")
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill. A new bucket starts full.
+func (b *tokenBucket) refill(limit rateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Limit)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(limit.Limit) / limit.per.Seconds()
+		if b.tokens > float64(limit.Limit) {
+			b.tokens = float64(limit.Limit)
+		}
+	}
+	b.last = now
+}
+
+// suppressedNotifications are the notifications to a single target which didn't fit in the rate limits, with the
+// latest subject per alert.
+type suppressedNotifications struct {
+	target   NotificationMessageTarget
+	labels   alertLabels
+	subjects map[string]string
+}
+
+// rateLimiter limits notifications per space, per org and globally with token buckets. Notifications over the
+// limits are counted per target and replaced by a single summary once the limits allow it again.
+type rateLimiter struct {
+	mu         sync.Mutex
+	config     rateLimitsConfig
+	buckets    map[string]*tokenBucket
+	suppressed map[string]*suppressedNotifications
+}
+
+func NewRateLimiter(config rateLimitsConfig) *rateLimiter {
+	return &rateLimiter{
+		config:     config,
+		buckets:    make(map[string]*tokenBucket),
+		suppressed: make(map[string]*suppressedNotifications),
+	}
+}
+
+// Allow takes a token from every applicable bucket, or from none if one of them is empty. Notifications which are
+// not allowed are remembered for the summary.
+func (r *rateLimiter) Allow(msg NotificationMessage, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.take(msg.Labels, now) {
+		key := msg.Target.Type + "/" + msg.Target.Id
+		s, ok := r.suppressed[key]
+		if !ok {
+			s = &suppressedNotifications{target: msg.Target, labels: msg.Labels, subjects: make(map[string]string)}
+			r.suppressed[key] = s
+		}
+		s.subjects[suppressedKey(msg)] = msg.Subject
+		return false
+	}
+
+	return true
+}
+
+// suppressedKey identifies the alert of a suppressed notification, so an alert is counted once however often it is
+// suppressed. Group notifications aren't about a single alert and are identified by their id.
+func suppressedKey(msg NotificationMessage) string {
+	if msg.Labels.InstanceGuid == "" {
+		return msg.Id
+	}
+
+	return msg.Labels.Key()
+}
+
+func (r *rateLimiter) take(labels alertLabels, now time.Time) bool {
+	type limitedBucket struct {
+		limit  rateLimit
+		bucket *tokenBucket
+	}
+
+	var applicable []limitedBucket
+	for key, limit := range map[string]rateLimit{
+		"space/" + labels.Org + "/" + labels.Space: r.config.Space,
+		"org/" + labels.Org:                        r.config.Org,
+		"global":                                   r.config.Global,
+	} {
+		if limit.Limit == 0 {
+			continue
+		}
+
+		bucket, ok := r.buckets[key]
+		if !ok {
+			bucket = &tokenBucket{}
+			r.buckets[key] = bucket
+		}
+		bucket.refill(limit, now)
+
+		if bucket.tokens < 1 {
+			return false
+		}
+		applicable = append(applicable, limitedBucket{limit, bucket})
+	}
+
+	for _, b := range applicable {
+		b.bucket.tokens--
+	}
+
+	return true
+}
+
+// Summaries returns one "N more alerts suppressed" message for every target with suppressed notifications for
+// which the limits allow a notification again.
+func (r *rateLimiter) Summaries(environment string, now time.Time) []NotificationMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var summaries []NotificationMessage
+	for key, s := range r.suppressed {
+		if !r.take(s.labels, now) {
+			continue
+		}
+		delete(r.suppressed, key)
+
+		keys := make([]string, 0, len(s.subjects))
+		for k := range s.subjects {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		subjects := make([]string, 0, len(keys))
+		for _, k := range keys {
+			subjects = append(subjects, s.subjects[k])
+		}
+		if len(subjects) > 20 {
+			subjects = append(subjects[:20:20], "...")
+		}
+
+		summaries = append(summaries, NotificationMessage{
+			Id:      fmt.Sprintf("%s-suppressed-%v", s.target.Id, now.Unix()),
+			Subject: fmt.Sprintf("(%s) %v more alerts suppressed for cloudfoundry org: %s/space: %s", environment, len(s.subjects), s.labels.Org, s.labels.Space),
+			Message: fmt.Sprintf("Too many alerts were generated for cloudfoundry org/space: %s/%s in environment %s. %v alerts were not sent:\n\n%s",
+				s.labels.Org, s.labels.Space, environment, len(s.subjects), strings.Join(subjects, "\n")),
+			Target:   s.target,
+			Status:   statusFiring,
+			Labels:   alertLabels{Org: s.labels.Org, Space: s.labels.Space},
+			StartsAt: now,
+		})
+	}
+
+	return summaries
+}
+
+var errRateLimited = errors.New("Rate limit exceeded, notification is included in a summary later")
+
+// send delivers msg within the rate limits. A notification which is rate limited is included in a summary later, so
+// callers should treat errRateLimited as notified, see notified.
+// Notifications which no sink wants, like resolved notifications without sinks sending those, don't count.
+func (a *alertServer) send(msg NotificationMessage) error {
+	deliveries := a.wantedDeliveries(routeNotification(a.routes, msg))
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if !a.rateLimiter.Allow(msg, time.Now()) {
+		return errRateLimited
+	}
+
+	return a.deliverAll(deliveries)
+}
+
+// sendRouted delivers a notification which was routed already within the rate limits.
+func (a *alertServer) sendRouted(d delivery) error {
+	if !a.outbox.sinks.wanted(d.msg, d.sinks) {
+		return nil
+	}
+	if !a.rateLimiter.Allow(d.msg, time.Now()) {
+		return errRateLimited
+	}
+
+	return a.outbox.Send(d.msg, d.sinks)
+}
+
+// wantedDeliveries drops the deliveries which none of their sinks want.
+func (a *alertServer) wantedDeliveries(deliveries []delivery) []delivery {
+	var wanted []delivery
+	for _, d := range deliveries {
+		if a.outbox.sinks.wanted(d.msg, d.sinks) {
+			wanted = append(wanted, d)
+		}
+	}
+
+	return wanted
+}
+
+// deliver routes msg and hands the deliveries to the outbox.
+func (a *alertServer) deliver(msg NotificationMessage) error {
+	return a.deliverAll(routeNotification(a.routes, msg))
+}
+
+func (a *alertServer) deliverAll(deliveries []delivery) error {
+	var errs sendErrors
+	for _, d := range deliveries {
+		if err := a.outbox.Send(d.msg, d.sinks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// notified tells whether a notification counts as sent after sending it returned err: it was sent now or before,
+// or it is included in a summary later.
+func notified(err error) bool {
+	return err == nil || errors.Is(err, errAlreadySent) || errors.Is(err, errRateLimited)
+}
+
+// sendSuppressedSummaries sends the summaries of notifications which were suppressed by the rate limits.
+func (a *alertServer) sendSuppressedSummaries() {
+	for _, msg := range a.rateLimiter.Summaries(a.environment, time.Now()) {
+		log.Println(msg.Subject)
+		if err := a.deliver(msg); err != nil {
+			log.Println("Notification not sent: ", err)
+		}
+	}
+}
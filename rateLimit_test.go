@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := rateLimit{Limit: 6, Per: "1h"}
+	if err := limit.parse("space"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var b tokenBucket
+
+	for _, step := range []struct {
+		name       string
+		after      time.Duration
+		take       float64
+		wantTokens float64
+	}{
+		{"a new bucket is full", 0, 6, 0},
+		{"one token per 10 minutes", 10 * time.Minute, 0, 1},
+		{"partial tokens", 15 * time.Minute, 0, 1.5},
+		{"never more than the limit", 5 * time.Hour, 0, 6},
+	} {
+		b.refill(limit, now.Add(step.after))
+		if b.tokens != step.wantTokens+step.take {
+			t.Errorf("%s: tokens = %v, want %v", step.name, b.tokens, step.wantTokens+step.take)
+		}
+		b.tokens -= step.take
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	config := rateLimitsConfig{Space: rateLimit{Limit: 1, Per: "1h"}, Global: rateLimit{Limit: 10, Per: "1h"}}
+	if err := config.parse(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRateLimiter(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := testMessage()
+	if !r.Allow(first, now) {
+		t.Fatalf("the first notification should be allowed")
+	}
+
+	//the same alert over the limit in several scans is suppressed once
+	over := testMessage()
+	over.Labels.InstanceGuid = "guid-2"
+	over.Labels.InstanceName = "other_db"
+	over.Subject = "Disk of other_db almost full"
+	for scan := 1; scan <= 5; scan++ {
+		if r.Allow(over, now.Add(time.Duration(scan)*time.Minute)) {
+			t.Fatalf("scan %d: notification over the limit was allowed", scan)
+		}
+	}
+
+	//another space has its own bucket
+	otherSpace := testMessage()
+	otherSpace.Labels.Space = "other"
+	if !r.Allow(otherSpace, now) {
+		t.Errorf("a notification in another space should be allowed")
+	}
+
+	if summaries := r.Summaries("test", now.Add(10*time.Minute)); len(summaries) != 0 {
+		t.Errorf("got a summary before the limit allows it: %+v", summaries)
+	}
+
+	summaries := r.Summaries("test", now.Add(time.Hour))
+	if len(summaries) != 1 {
+		t.Fatalf("got %d summaries, want 1", len(summaries))
+	}
+	if !strings.HasPrefix(summaries[0].Subject, "(test) 1 more alerts suppressed") {
+		t.Errorf("subject = %q", summaries[0].Subject)
+	}
+	if !strings.HasSuffix(summaries[0].Message, "\n\nDisk of other_db almost full") {
+		t.Errorf("message = %q", summaries[0].Message)
+	}
+	if summaries[0].Target.Id != over.Target.Id {
+		t.Errorf("target = %+v, want %+v", summaries[0].Target, over.Target)
+	}
+
+	if summaries := r.Summaries("test", now.Add(3*time.Hour)); len(summaries) != 0 {
+		t.Errorf("suppressed notifications are summarized once, got %+v", summaries)
+	}
+}
+
+func TestRateLimiterGlobalLimit(t *testing.T) {
+	config := rateLimitsConfig{Space: rateLimit{Limit: 5, Per: "1h"}, Global: rateLimit{Limit: 1, Per: "1h"}}
+	if err := config.parse(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRateLimiter(config)
+	now := time.Now()
+
+	r.Allow(testMessage(), now)
+	if r.Allow(testMessage(), now) {
+		t.Errorf("the global limit should apply")
+	}
+
+	//a rejected notification takes no tokens from the other buckets
+	if tokens := r.buckets["space/org/space"].tokens; tokens != 4 {
+		t.Errorf("space bucket has %v tokens, want 4", tokens)
+	}
+}
+
+func TestOnlyWantedNotificationsAreRateLimited(t *testing.T) {
+	config := rateLimitsConfig{Space: rateLimit{Limit: 1, Per: "1h"}}
+	if err := config.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	sink := func(name string, perAlert bool) namedNotifier {
+		return namedNotifier{name: name, id: name, perAlert: perAlert, notifier: funcNotifier(func(msg NotificationMessage) error {
+			sent = append(sent, name+":"+msg.Status)
+			return nil
+		})}
+	}
+	a := &alertServer{
+		outbox:      newTestOutbox(t, notifiers{sink("slack", false), sink("pagerduty", true)}),
+		rateLimiter: NewRateLimiter(config),
+	}
+
+	//no sink sends resolved notifications, so this one doesn't use the only token
+	resolved := testMessage()
+	resolved.Status = statusResolved
+	if err := a.send(resolved); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.send(testMessage()); err != nil {
+		t.Fatalf("firing notification after an unwanted one: %v", err)
+	}
+	if len(a.rateLimiter.suppressed) != 0 {
+		t.Errorf("unwanted notification was suppressed")
+	}
+
+	summary := NotificationMessage{Id: "space-guid-suppressed-1", Subject: "3 more alerts suppressed", Status: statusFiring, Labels: alertLabels{Org: "org", Space: "space"}}
+	if err := a.deliver(summary); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"slack:firing", "pagerduty:firing", "slack:firing"}
+	if strings.Join(sent, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v: summaries don't go to sinks tracking single alerts", sent, want)
+	}
+}
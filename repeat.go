@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// repeatSchedule sets the wait before each reminder of a firing alert, for example 1h, 4h and 24h. The last interval
+// is repeated, so it caps the wait. With StopAfter set no more than that many reminders are sent.
+type repeatSchedule struct {
+	Intervals []string `json:"intervals"`
+	StopAfter int      `json:"stop_after"`
+
+	intervals []time.Duration
+}
+
+func (s *repeatSchedule) parse() error {
+	if len(s.Intervals) == 0 {
+		return fmt.Errorf("repeat needs at least one interval")
+	}
+
+	for _, interval := range s.Intervals {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return fmt.Errorf("invalid repeat interval %q: %v", interval, err)
+		}
+		s.intervals = append(s.intervals, d)
+	}
+
+	return nil
+}
+
+// notificationDue reports whether a firing alert should be notified now: when it wasn't notified yet since it
+// started firing, or when its next reminder is due. Without a repeat schedule reminders are sent every
+// notification_interval.
+func (rule *alertRule) notificationDue(state alertState, now time.Time) bool {
+	if !state.Notified() {
+		return true
+	}
+
+	if rule.Repeat == nil {
+		return now.Sub(state.LastNotified) >= rule.notifyInterval
+	}
+
+	if rule.Repeat.StopAfter > 0 && state.Reminders >= rule.Repeat.StopAfter {
+		return false
+	}
+
+	i := state.Reminders
+	if i >= len(rule.Repeat.intervals) {
+		i = len(rule.Repeat.intervals) - 1
+	}
+
+	return now.Sub(state.LastNotified) >= rule.Repeat.intervals[i]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationDue(t *testing.T) {
+	schedule := &repeatSchedule{Intervals: []string{"1h", "4h"}, StopAfter: 3}
+	if err := schedule.parse(); err != nil {
+		t.Fatal(err)
+	}
+	interval := alertRule{notifyInterval: 30 * time.Minute}
+	repeat := alertRule{notifyInterval: 30 * time.Minute, Repeat: schedule}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	notified := func(after time.Duration, reminders int) alertState {
+		return alertState{FiringSince: start, LastNotified: start.Add(after), Reminders: reminders}
+	}
+
+	for _, test := range []struct {
+		name  string
+		rule  alertRule
+		state alertState
+		after time.Duration
+		want  bool
+	}{
+		{"not notified yet", interval, alertState{FiringSince: start}, 0, true},
+		{"notified before it started firing again", interval, alertState{FiringSince: start, LastNotified: start.Add(-time.Minute)}, 0, true},
+		{"within notification_interval", interval, notified(0, 0), 29 * time.Minute, false},
+		{"after notification_interval", interval, notified(0, 0), 30 * time.Minute, true},
+		{"first reminder waits the first interval", repeat, notified(0, 0), 59 * time.Minute, false},
+		{"first reminder", repeat, notified(0, 0), time.Hour, true},
+		{"second reminder waits the second interval", repeat, notified(time.Hour, 1), 4 * time.Hour, false},
+		{"the last interval is repeated", repeat, notified(5*time.Hour, 2), 9 * time.Hour, true},
+		{"stop_after", repeat, notified(9*time.Hour, 3), 48 * time.Hour, false},
+	} {
+		if got := test.rule.notificationDue(test.state, start.Add(test.after)); got != test.want {
+			t.Errorf("%s: due = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInvalidNotificationInterval(t *testing.T) {
+	for _, test := range []struct {
+		config  string
+		wantErr string
+	}{
+		{`{"rules": {"redis": [{"name": "memory", "notification_interval": "1 hour"}]}}`, "rule memory for service redis: invalid notification_interval"},
+		{`{"rules": {}, "alertmanager_receiver": {"notification_interval": "daily"}}`, "invalid alertmanager_receiver notification_interval"},
+	} {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := ioutil.WriteFile(path, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := rulesConfigLoad(path); err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+			t.Errorf("got %v, want %s", err, test.wantErr)
+		}
+	}
+
+	rules, err := rulesConfigLoad("rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := rules.Rules["redis"][0]; rule.notifyInterval == 0 {
+		t.Errorf("notification_interval %q of the example rule was not parsed", rule.NotifyInterval)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// routeMatchers are regular expressions matched against the full label values of an alert. Empty matchers match
+// anything.
+type routeMatchers struct {
+	Org      string `json:"org"`
+	Space    string `json:"space"`
+	Service  string `json:"service"`
+	Plan     string `json:"plan"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+
+	compiled []labelMatcher
+}
+
+type labelMatcher struct {
+	value func(alertLabels) string
+	re    *regexp.Regexp
+}
+
+// route sends matching alerts to Sinks (all sinks when empty) addressed to Targets (the targets of the rule when
+// empty). Routes are tried in order, the first matching route wins unless it has Continue set.
+type route struct {
+	Match    routeMatchers `json:"match"`
+	Sinks    []string      `json:"sinks"`
+	Targets  []string      `json:"targets"`
+	Continue bool          `json:"continue"`
+
+	Escalations []escalationStep `json:"escalations"`
+
+	targets []target
+}
+
+func (r *route) parse() error {
+	for _, m := range []struct {
+		name    string
+		pattern string
+		value   func(alertLabels) string
+	}{
+		{"org", r.Match.Org, func(l alertLabels) string { return l.Org }},
+		{"space", r.Match.Space, func(l alertLabels) string { return l.Space }},
+		{"service", r.Match.Service, func(l alertLabels) string { return l.Service }},
+		{"plan", r.Match.Plan, func(l alertLabels) string { return l.Plan }},
+		{"rule", r.Match.Rule, func(l alertLabels) string { return l.Rule }},
+		{"severity", r.Match.Severity, func(l alertLabels) string { return l.Severity }},
+	} {
+		if m.pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile("^(?:" + m.pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid route matcher %s %q: %v", m.name, m.pattern, err)
+		}
+		r.Match.compiled = append(r.Match.compiled, labelMatcher{value: m.value, re: re})
+	}
+
+	var err error
+	if r.targets, err = parseTargets(r.Targets); err != nil {
+		return fmt.Errorf("invalid route: %v", err)
+	}
+
+	return parseEscalations(r.Escalations)
+}
+
+func (r route) matches(labels alertLabels) bool {
+	for _, m := range r.Match.compiled {
+		if !m.re.MatchString(m.value(labels)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateSinkNames checks that all sinks referred to by routes and escalation steps exist.
+func validateSinkNames(rules rulesConfig, sinks notifiers) error {
+	names := make(map[string]bool)
+	for _, sink := range sinks {
+		names[sink.name] = true
+	}
+
+	var referred []string
+	for _, r := range rules.Routes {
+		referred = append(referred, r.Sinks...)
+		for _, step := range r.Escalations {
+			referred = append(referred, step.Sinks...)
+		}
+	}
+	for _, ruleSet := range rules.Rules {
+		for _, rule := range ruleSet {
+			for _, step := range rule.Escalations {
+				referred = append(referred, step.Sinks...)
+			}
+		}
+	}
+
+	for _, name := range referred {
+		if !names[name] {
+			return fmt.Errorf("unknown notifier %q", name)
+		}
+	}
+
+	return nil
+}
+
+// delivery is a notification addressed to a target, to be sent to sinks (all sinks when nil).
+type delivery struct {
+	msg   NotificationMessage
+	sinks []string
+}
+
+// routeNotification returns the deliveries for msg. Without routes, or when no route matches, msg goes to all sinks
+// addressed to the targets of its rule.
+func routeNotification(routes []route, msg NotificationMessage) []delivery {
+	var deliveries []delivery
+	matched := false
+	for _, r := range routes {
+		if !r.matches(msg.Labels) {
+			continue
+		}
+
+		matched = true
+		deliveries = append(deliveries, targetDeliveries(msg, r.targets, r.Sinks)...)
+		if !r.Continue {
+			break
+		}
+	}
+
+	if !matched {
+		deliveries = targetDeliveries(msg, nil, nil)
+	}
+
+	return deliveries
+}
+
+// targetDeliveries addresses msg to each of targets, or to the targets of its rule when there are none.
+func targetDeliveries(msg NotificationMessage, targets []target, sinks []string) []delivery {
+	if len(targets) == 0 {
+		targets = msg.Targets
+	}
+	if len(targets) == 0 {
+		return []delivery{{msg: msg, sinks: sinks}}
+	}
+
+	var deliveries []delivery
+	for _, t := range targets {
+		if retargeted, ok := retarget(msg, t); ok {
+			deliveries = append(deliveries, delivery{msg: retargeted, sinks: sinks})
+		}
+	}
+
+	return deliveries
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteNotification(t *testing.T) {
+	routes := []route{
+		{Match: routeMatchers{Severity: "critical"}, Sinks: []string{"pagerduty"}, Continue: true},
+		{Match: routeMatchers{Service: "postgres|mysql"}, Sinks: []string{"dba-slack"}, Targets: []string{"team:dba"}},
+		{Match: routeMatchers{Org: "org"}, Sinks: []string{"cfns"}},
+	}
+	for i := range routes {
+		if err := routes[i].parse(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	describe := func(deliveries []delivery) string {
+		var described []string
+		for _, d := range deliveries {
+			described = append(described, strings.Join(d.sinks, "+")+">"+d.msg.Target.Type+":"+d.msg.Target.Id)
+		}
+		return strings.Join(described, ",")
+	}
+
+	for _, test := range []struct {
+		name   string
+		labels func(*alertLabels)
+		want   string
+	}{
+		{"continue to the first route after it", func(l *alertLabels) {}, "pagerduty>space:space-guid,dba-slack>team:dba"},
+		{"first matching route wins", func(l *alertLabels) { l.Severity = "warning" }, "dba-slack>team:dba"},
+		{"matchers match full values", func(l *alertLabels) { l.Severity = "warning"; l.Service = "postgresql" }, "cfns>space:space-guid"},
+		{"no route matches", func(l *alertLabels) { l.Severity = "warning"; l.Service = "redis"; l.Org = "other" }, ">space:space-guid"},
+	} {
+		msg := testMessage()
+		test.labels(&msg.Labels)
+		if got := describe(routeNotification(routes, msg)); got != test.want {
+			t.Errorf("%s: routed to %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInvalidRoutes(t *testing.T) {
+	r := route{Match: routeMatchers{Rule: "("}}
+	if err := r.parse(); err == nil {
+		t.Errorf("invalid matcher was accepted")
+	}
+
+	r = route{Targets: []string{"nowhere"}}
+	if err := r.parse(); err == nil {
+		t.Errorf("invalid target was accepted")
+	}
+
+	rules := rulesConfig{Routes: []route{{Sinks: []string{"slack", "pager"}}}}
+	if err := validateSinkNames(rules, notifiers{{name: "slack"}}); err == nil || err.Error() != `unknown notifier "pager"` {
+		t.Errorf("got %v for an unknown sink", err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// silenceMatchers select the alerts a silence applies to. Empty fields match anything.
+type silenceMatchers struct {
+	Org          string `json:"org,omitempty"`
+	Space        string `json:"space,omitempty"`
+	InstanceGuid string `json:"service_instance_guid,omitempty"`
+	Service      string `json:"service,omitempty"`
+	Rule         string `json:"rule,omitempty"`
+}
+
+func (m silenceMatchers) Matches(labels alertLabels) bool {
+	return matchLabel(m.Org, labels.Org) &&
+		matchLabel(m.Space, labels.Space) &&
+		matchLabel(m.InstanceGuid, labels.InstanceGuid) &&
+		matchLabel(m.Service, labels.Service) &&
+		matchLabel(m.Rule, labels.Rule)
+}
+
+func matchLabel(matcher, value string) bool {
+	return matcher == "" || matcher == value
+}
+
+type silence struct {
+	Id        string          `json:"id"`
+	Matchers  silenceMatchers `json:"matchers"`
+	StartsAt  time.Time       `json:"starts_at"`
+	EndsAt    time.Time       `json:"ends_at"`
+	CreatedBy string          `json:"created_by"`
+	Comment   string          `json:"comment"`
+}
+
+func (s silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s silence) Validate() error {
+	if s.Matchers == (silenceMatchers{}) {
+		return fmt.Errorf("at least one matcher is required")
+	}
+	if s.EndsAt.IsZero() {
+		return fmt.Errorf("ends_at is required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if s.CreatedBy == "" {
+		return fmt.Errorf("created_by is required")
+	}
+
+	return nil
+}
+
+// silenceStore keeps silences in the state store. Silences are read from the store on every access so changes made
+// through another instance are picked up, and changed under the lock of the store so no instance overwrites changes
+// made by another.
+type silenceStore struct {
+	mu    sync.Mutex
+	store stateStore
+}
+
+const silencesStateName = "silences"
+
+func NewSilenceStore(store stateStore) *silenceStore {
+	return &silenceStore{store: store}
+}
+
+func (s *silenceStore) List() ([]silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+func (s *silenceStore) Get(id string) (silence, bool, error) {
+	silences, err := s.List()
+	if err != nil {
+		return silence{}, false, err
+	}
+
+	for _, sil := range silences {
+		if sil.Id == id {
+			return sil, true, nil
+		}
+	}
+
+	return silence{}, false, nil
+}
+
+func (s *silenceStore) Add(sil silence, now time.Time) (silence, error) {
+	if sil.StartsAt.IsZero() {
+		sil.StartsAt = now
+	}
+	if err := sil.Validate(); err != nil {
+		return silence{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return silence{}, err
+	}
+	sil.Id = hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.store.Lock(silencesStateName)
+	if err != nil {
+		return silence{}, err
+	}
+	defer unlock()
+
+	silences, err := s.load()
+	if err != nil {
+		return silence{}, err
+	}
+
+	//silences that ended more than a day ago are of no use anymore
+	var kept []silence
+	for _, existing := range silences {
+		if now.Sub(existing.EndsAt) < 24*time.Hour {
+			kept = append(kept, existing)
+		}
+	}
+
+	return sil, s.store.Save(silencesStateName, append(kept, sil))
+}
+
+// Expire ends a silence immediately. It returns false if no silence with the given id exists.
+func (s *silenceStore) Expire(id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.store.Lock(silencesStateName)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	silences, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	for i := range silences {
+		if silences[i].Id == id {
+			if silences[i].EndsAt.After(now) {
+				silences[i].EndsAt = now
+			}
+			return true, s.store.Save(silencesStateName, silences)
+		}
+	}
+
+	return false, nil
+}
+
+// matchSilence returns the first active silence matching the alert, or nil.
+func matchSilence(silences []silence, labels alertLabels, now time.Time) *silence {
+	for _, sil := range silences {
+		if sil.Active(now) && sil.Matchers.Matches(labels) {
+			return &sil
+		}
+	}
+
+	return nil
+}
+
+func (s *silenceStore) load() ([]silence, error) {
+	var silences []silence
+	if err := s.store.Load(silencesStateName, &silences); err != nil {
+		return nil, fmt.Errorf("Unable to load silences: %v", err)
+	}
+
+	return silences, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSilenceMatching(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	labels := testMessage().Labels
+
+	for _, test := range []struct {
+		name    string
+		silence silence
+		wants   bool
+	}{
+		{"matching instance", silence{Matchers: silenceMatchers{InstanceGuid: "guid-1"}, StartsAt: now, EndsAt: now.Add(time.Hour)}, true},
+		{"matching org and rule", silence{Matchers: silenceMatchers{Org: "org", Rule: "disk"}, StartsAt: now, EndsAt: now.Add(time.Hour)}, true},
+		{"other rule", silence{Matchers: silenceMatchers{Org: "org", Rule: "memory"}, StartsAt: now, EndsAt: now.Add(time.Hour)}, false},
+		{"not started", silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now.Add(time.Minute), EndsAt: now.Add(time.Hour)}, false},
+		{"ended", silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now.Add(-time.Hour), EndsAt: now}, false},
+	} {
+		if got := matchSilence([]silence{test.silence}, labels, now) != nil; got != test.wants {
+			t.Errorf("%s: matches = %v, want %v", test.name, got, test.wants)
+		}
+	}
+}
+
+func TestSilenceValidation(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		silence silence
+		wantErr string
+	}{
+		{silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now, EndsAt: now.Add(time.Hour), CreatedBy: "jane"}, ""},
+		{silence{StartsAt: now, EndsAt: now.Add(time.Hour), CreatedBy: "jane"}, "at least one matcher is required"},
+		{silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now, CreatedBy: "jane"}, "ends_at is required"},
+		{silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now, EndsAt: now, CreatedBy: "jane"}, "ends_at must be after starts_at"},
+		{silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now, EndsAt: now.Add(time.Hour)}, "created_by is required"},
+	} {
+		err := test.silence.Validate()
+		if (err == nil && test.wantErr != "") || (err != nil && err.Error() != test.wantErr) {
+			t.Errorf("got %v, want %q", err, test.wantErr)
+		}
+	}
+}
+
+func TestSilenceStore(t *testing.T) {
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	silences := NewSilenceStore(store)
+	now := time.Now()
+
+	old, err := silences.Add(silence{Matchers: silenceMatchers{Org: "org"}, StartsAt: now.Add(-72 * time.Hour), EndsAt: now.Add(-48 * time.Hour), CreatedBy: "jane"}, now.Add(-72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sil, err := silences.Add(silence{Matchers: silenceMatchers{Space: "space"}, EndsAt: now.Add(time.Hour), CreatedBy: "jane"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sil.Id == "" || !sil.StartsAt.Equal(now) {
+		t.Errorf("unexpected silence %+v", sil)
+	}
+
+	if _, found, _ := silences.Get(old.Id); found {
+		t.Errorf("silences that ended more than a day ago should be removed")
+	}
+
+	expired, err := silences.Expire(sil.Id, now.Add(time.Minute))
+	if err != nil || !expired {
+		t.Fatalf("Expire = %v, %v", expired, err)
+	}
+	if got, _, _ := silences.Get(sil.Id); got.Active(now.Add(time.Minute)) {
+		t.Errorf("expired silence is still active")
+	}
+	if expired, _ := silences.Expire("unknown", now); expired {
+		t.Errorf("expired an unknown silence")
+	}
+}
+
+func TestSilencesOfInstancesSharingTheStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	//every instance has its own silenceStore on the shared directory
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		store, err := NewFileStateStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		silences := NewSilenceStore(store)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				sil := silence{Matchers: silenceMatchers{Rule: fmt.Sprintf("rule-%d-%d", i, j)}, EndsAt: now.Add(time.Hour), CreatedBy: "jane"}
+				if _, err := silences.Add(sil, now); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, _ := NewFileStateStore(dir)
+	all, err := NewSilenceStore(store).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 20 {
+		t.Errorf("got %d silences, want 20", len(all))
+	}
+}
+
+func TestStaleLocksAreTakenOver(t *testing.T) {
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Lock("silences"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the lock is never released, as if the instance holding it crashed
+	stale := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(store.path("silences")+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := store.Lock("silences")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// escapeSlack escapes the characters Slack uses for links and mentions.
+var escapeSlack = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+type slackNotifier struct {
+	url        string
+	httpClient http.Client
+}
+
+func NewSlackNotifier(config notifierConfig) (*slackNotifier, error) {
+	if err := requireFields(map[string]string{"url": config.URL}); err != nil {
+		return nil, err
+	}
+
+	return &slackNotifier{
+		url:        config.URL,
+		httpClient: newNotifierHttpClient(),
+	}, nil
+}
+
+// Send posts to a Slack incoming webhook.
+func (n *slackNotifier) Send(msg NotificationMessage) error {
+	return postJSON(n.httpClient, n.url, nil, struct {
+		Text string `json:"text"`
+	}{
+		Text: fmt.Sprintf("*%s*\n%s", escapeSlack(msg.Subject), slackMessage(msg)),
+	})
+}
+
+func slackMessage(msg NotificationMessage) string {
+	if msg.Format == formatMarkdown {
+		return markdownToSlack(msg.Message)
+	}
+
+	return escapeSlack(msg.Message)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+const (
+	smtpTimeout = 30 * time.Second
+	smtpsPort   = 465 //the port on which mail servers expect TLS from the start instead of STARTTLS
+)
+
+// smtpNotifier sends alerts as email without cfNotificationService. Recipients are the static to addresses plus,
+// when roles are configured, the users the target refers to: the users having the roles of the target (or else the
+// configured roles) in the target space or org, or the target user. Those are looked up through the CF API and UAA
+// and are not disclosed to each other. Team targets only get the static to addresses.
+type smtpNotifier struct {
+	addr        string
+	auth        smtp.Auth
+	from        string
+	to          []string
+	roles       []string
+	cfClient    *cfclient.Client
+	timeout     time.Duration
+	implicitTLS bool
+	rootCAs     *x509.CertPool //nil for the system roots
+}
+
+func NewSmtpNotifier(config notifierConfig, cfClient *cfclient.Client) (*smtpNotifier, error) {
+	if err := requireFields(map[string]string{"host": config.Host, "from": config.From}); err != nil {
+		return nil, err
+	}
+	if len(config.To) == 0 && len(config.Roles) == 0 {
+		return nil, fmt.Errorf("to or roles is required")
+	}
+	if err := validateRoles(config.Roles); err != nil {
+		return nil, err
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = 25
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	return &smtpNotifier{
+		addr:        net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		auth:        auth,
+		from:        config.From,
+		to:          config.To,
+		roles:       config.Roles,
+		cfClient:    cfClient,
+		timeout:     smtpTimeout,
+		implicitTLS: port == smtpsPort,
+	}, nil
+}
+
+func (n *smtpNotifier) Send(msg NotificationMessage) error {
+	recipients := append([]string{}, n.to...)
+
+	if len(n.roles) > 0 {
+		userGuids, err := n.targetUsers(msg.Target)
+		if err != nil {
+			return lookupError(err)
+		}
+
+		emails, err := userEmails(n.cfClient, userGuids)
+		if err != nil {
+			return lookupError(err)
+		}
+		recipients = append(recipients, emails...)
+	}
+
+	if len(recipients) == 0 {
+		return permanentError{fmt.Errorf("No recipients found")}
+	}
+
+	mail, err := n.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := n.sendMail(recipients, mail); err != nil {
+		var smtpErr *textproto.Error
+		if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+			return permanentError{fmt.Errorf("Error sending mail: %v", err)}
+		}
+		return fmt.Errorf("Error sending mail: %v", err)
+	}
+
+	return nil
+}
+
+// lookupError makes failing to look up the recipients permanent when retrying won't help: the target no longer
+// exists or UAA refuses the request, for example because the scim.read scope is missing.
+func lookupError(err error) error {
+	var uaaErr uaaStatusError
+	if cfclient.IsResourceNotFoundError(err) || (errors.As(err, &uaaErr) && uaaErr.permanent()) {
+		return permanentError{err}
+	}
+	return err
+}
+
+// sendMail works like smtp.SendMail, but with a timeout on connecting and on the whole conversation so a hanging
+// mail server doesn't block notifications. On the smtps port the connection is TLS from the start, elsewhere
+// STARTTLS is used when the server supports it.
+func (n *smtpNotifier) sendMail(recipients []string, mail []byte) error {
+	host, _, _ := net.SplitHostPort(n.addr)
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: n.rootCAs}
+
+	dialer := &net.Dialer{Timeout: n.timeout}
+	var conn net.Conn
+	var err error
+	if n.implicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", n.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !n.implicitTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(n.auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mail); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (n *smtpNotifier) targetUsers(target NotificationMessageTarget) ([]string, error) {
+	roles := n.roles
+	if len(target.Roles) > 0 {
+		roles = target.Roles
+	}
+
+	var userGuids []string
+	var err error
+	switch target.Type {
+	case "space":
+		userGuids, err = userGuidsWithRoles(n.cfClient, target.Id, "", roles)
+	case "org":
+		userGuids, err = userGuidsWithRoles(n.cfClient, "", target.Id, roles)
+	case "user":
+		userGuids = []string{target.Id}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up users with roles %v: %w", roles, err)
+	}
+
+	return userGuids, nil
+}
+
+// compose builds a multipart/alternative mail with a plain text and a html version of the message. A chart is
+// shown inline in the html version, through a multipart/related part.
+func (n *smtpNotifier) compose(msg NotificationMessage) ([]byte, error) {
+	to := "undisclosed-recipients:;"
+	if len(n.to) > 0 {
+		to = strings.Join(n.to, ", ")
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	plain, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(plain, "%s\r\n", msg.PlainMessage())
+
+	htmlMessage := "<p>" + strings.ReplaceAll(html.EscapeString(msg.Message), "\n", "<br>") + "</p>"
+	if msg.Format == formatMarkdown {
+		htmlMessage = markdownToHTML(msg.Message)
+	}
+
+	if len(msg.Chart) == 0 {
+		htmlPart, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(htmlPart, "<html><body><h3>%s</h3>%s</body></html>\r\n", html.EscapeString(msg.Subject), htmlMessage)
+	} else if err := writeHtmlWithChart(parts, msg, htmlMessage); err != nil {
+		return nil, err
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", n.from)
+	fmt.Fprintf(&mail, "To: %s\r\n", to)
+	fmt.Fprintf(&mail, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&mail, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&mail, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&mail, "Content-Type: multipart/alternative; boundary=%s\r\n", parts.Boundary())
+	fmt.Fprintf(&mail, "\r\n")
+	mail.Write(body.Bytes())
+
+	return mail.Bytes(), nil
+}
+
+func writeHtmlWithChart(parts *multipart.Writer, msg NotificationMessage, htmlMessage string) error {
+	var related bytes.Buffer
+	relatedParts := multipart.NewWriter(&related)
+
+	htmlPart, err := relatedParts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(htmlPart, "<html><body><h3>%s</h3>%s<p><img src=\"cid:chart@cfservicealert\" alt=\"chart\"></p></body></html>\r\n", html.EscapeString(msg.Subject), htmlMessage)
+
+	chartPart, err := relatedParts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Id":                {"<chart@cfservicealert>"},
+		"Content-Disposition":       {`inline; filename="chart.png"`},
+	})
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(msg.Chart)
+	for len(encoded) > 76 {
+		fmt.Fprintf(chartPart, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(chartPart, "%s\r\n", encoded)
+
+	if err := relatedParts.Close(); err != nil {
+		return err
+	}
+
+	relatedPart, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/related; boundary=" + relatedParts.Boundary()}})
+	if err != nil {
+		return err
+	}
+	_, err = relatedPart.Write(related.Bytes())
+	return err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// fakeSmtpServer accepts a single mail and sends it on mails. When silent it accepts the connection but never
+// replies.
+func fakeSmtpServer(t *testing.T, silent bool) (string, chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serveSmtp(t, listener, silent)
+}
+
+// fakeSmtpsServer is a fakeSmtpServer which expects TLS from the start, like mail servers on the smtps port. The
+// returned pool trusts its certificate.
+func fakeSmtpsServer(t *testing.T) (string, chan []byte, *x509.CertPool) {
+	certServer := httptest.NewTLSServer(nil)
+	certServer.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certServer.Certificate())
+	addr, mails := serveSmtp(t, listener, false)
+	return addr, mails, pool
+}
+
+func serveSmtp(t *testing.T, listener net.Listener, silent bool) (string, chan []byte) {
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			ioutil.ReadAll(conn)
+			return
+		}
+
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 Go ahead")
+				data, err := c.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				mails <- data
+				c.PrintfLine("250 OK")
+			case "QUIT":
+				c.PrintfLine("221 Bye")
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), mails
+}
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// readParts returns the parts of a multipart body by content type.
+func readParts(t *testing.T, contentType string, body []byte) map[string]mimePart {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		t.Fatalf("content type %q is not multipart", contentType)
+	}
+
+	parts := map[string]mimePart{}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		partBody, _ := ioutil.ReadAll(part)
+		parts[partType] = mimePart{header: part.Header, body: partBody}
+	}
+
+	return parts
+}
+
+func TestSmtpMimeStructure(t *testing.T) {
+	addr, mails := fakeSmtpServer(t, false)
+	n := &smtpNotifier{addr: addr, from: "alerts@example.com", to: []string{"ops@example.com"}, timeout: 5 * time.Second}
+
+	if err := n.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(<-mails))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Get("To") != "ops@example.com" || m.Header.Get("Subject") != "Disk almost full" {
+		t.Errorf("unexpected headers %v", m.Header)
+	}
+	body, _ := ioutil.ReadAll(m.Body)
+
+	parts := readParts(t, m.Header.Get("Content-Type"), body)
+	if plain := string(parts["text/plain"].body); plain != "Disk of my_db is **90%** full\n" {
+		t.Errorf("text/plain = %q", plain)
+	}
+	if html := string(parts["text/html"].body); html != "<html><body><h3>Disk almost full</h3><p>Disk of my_db is **90%** full</p></body></html>\n" {
+		t.Errorf("text/html = %q", html)
+	}
+}
+
+func TestSmtpChart(t *testing.T) {
+	addr, mails := fakeSmtpServer(t, false)
+	n := &smtpNotifier{addr: addr, from: "alerts@example.com", to: []string{"ops@example.com"}, timeout: 5 * time.Second}
+
+	chart := []byte("\x89PNG fake chart")
+	msg := testMessage()
+	msg.Chart = chart
+	if err := n.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(<-mails))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(m.Body)
+
+	parts := readParts(t, m.Header.Get("Content-Type"), body)
+	if _, ok := parts["text/plain"]; !ok {
+		t.Errorf("missing text/plain part")
+	}
+	if _, ok := parts["text/html"]; ok {
+		t.Fatalf("html should be in the multipart/related part")
+	}
+	related, ok := parts["multipart/related"]
+	if !ok {
+		t.Fatalf("missing multipart/related part")
+	}
+	relatedParts := readParts(t, related.header.Get("Content-Type"), related.body)
+
+	image, ok := relatedParts["image/png"]
+	if !ok || image.header.Get("Content-Id") != "<chart@cfservicealert>" {
+		t.Fatalf("missing chart part")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(image.body), "\n", ""))
+	if err != nil || !bytes.Equal(decoded, chart) {
+		t.Errorf("chart = %q, %v", decoded, err)
+	}
+	if html := string(relatedParts["text/html"].body); !strings.Contains(html, `<img src="cid:chart@cfservicealert"`) {
+		t.Errorf("text/html = %q", html)
+	}
+}
+
+func TestSmtpTimeout(t *testing.T) {
+	addr, _ := fakeSmtpServer(t, true)
+	n := &smtpNotifier{addr: addr, from: "alerts@example.com", to: []string{"ops@example.com"}, timeout: 100 * time.Millisecond}
+
+	done := make(chan error, 1)
+	go func() { done <- n.Send(testMessage()) }()
+
+	select {
+	case err := <-done:
+		if err == nil || isPermanent(err) {
+			t.Errorf("got %v, want a retryable error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send did not time out")
+	}
+}
+
+func TestSmtpImplicitTLS(t *testing.T) {
+	addr, mails, pool := fakeSmtpsServer(t)
+	n := &smtpNotifier{addr: addr, from: "alerts@example.com", to: []string{"ops@example.com"}, timeout: 5 * time.Second, implicitTLS: true, rootCAs: pool}
+
+	if err := n.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := mail.ReadMessage(bytes.NewReader(<-mails)); err != nil || m.Header.Get("Subject") != "Disk almost full" {
+		t.Errorf("unexpected mail %v, %v", m, err)
+	}
+
+	config := notifierConfig{Host: "smtp.example.com", Port: smtpsPort, From: "alerts@example.com", To: []string{"ops@example.com"}}
+	if n, _ := NewSmtpNotifier(config, nil); !n.implicitTLS {
+		t.Errorf("port %d doesn't use implicit TLS", smtpsPort)
+	}
+}
+
+func TestSmtpPermanentErrors(t *testing.T) {
+	//team targets only get the static to addresses, of which there are none
+	n := &smtpNotifier{from: "alerts@example.com", roles: []string{"space_developer"}, timeout: 5 * time.Second}
+	msg := testMessage()
+	msg.Target = NotificationMessageTarget{Type: "team", Id: "dba"}
+	if err := n.Send(msg); !isPermanent(err) {
+		t.Errorf("no recipients returned %v, want a permanent error", err)
+	}
+
+	for _, test := range []struct {
+		err       error
+		permanent bool
+	}{
+		{uaaStatusError{http.StatusForbidden}, true},
+		{uaaStatusError{http.StatusTooManyRequests}, false},
+		{uaaStatusError{http.StatusServiceUnavailable}, false},
+		{fmt.Errorf("Error looking up users: %w", cfclient.NewResourceNotFoundError()), true},
+		{errors.New("connection refused"), false},
+	} {
+		if got := isPermanent(lookupError(test.err)); got != test.permanent {
+			t.Errorf("%v: permanent = %v, want %v", test.err, got, test.permanent)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateStore persists cfServiceAlert's own state (alert states, silences) between restarts.
+type stateStore interface {
+	Load(name string, v interface{}) error
+	Save(name string, v interface{}) error
+	//Lock takes an exclusive lock on name for a read-modify-write, also against other instances sharing the store.
+	//It returns the function releasing the lock.
+	Lock(name string) (func(), error)
+}
+
+const (
+	lockTimeout  = 10 * time.Second
+	staleLockAge = time.Minute //an instance crashing while holding a lock would otherwise leave it locked forever
+)
+
+// fileStateStore stores every named object as a json file in a directory. When running multiple instances the
+// directory should be on a shared volume so all instances see the same silences.
+type fileStateStore struct {
+	dir string
+}
+
+func NewFileStateStore(dir string) (*fileStateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create state directory: %v", err)
+	}
+
+	return &fileStateStore{dir: dir}, nil
+}
+
+// Load leaves v untouched when nothing has been saved under name yet.
+func (s *fileStateStore) Load(name string, v interface{}) error {
+	inBuf, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(inBuf, v)
+}
+
+func (s *fileStateStore) Save(name string, v interface{}) error {
+	outBuf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	//write to a temp file first so a crash or a concurrent reader never sees a half written file
+	tmp, err := ioutil.TempFile(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(outBuf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+// Lock creates a lock file next to the state file. Creating it fails while another instance holds the lock, also on
+// NFS.
+func (s *fileStateStore) Lock(name string) (func(), error) {
+	lockPath := s.path(name) + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to lock %s: %v", name, err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Unable to lock %s: locked by another instance", name)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s *fileStateStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
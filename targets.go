@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// target is where a notification should go, written as "<type>" or "<type>:<argument>":
+//
+//	space                      everyone cfNotificationService notifies for the space
+//	space:<role>[,<role>...]   users with those space roles, for example space:space_manager
+//	org                        the org managers
+//	org:<role>[,<role>...]     users with those org roles
+//	user:<user guid>           a single user
+//	team:<name>                a static operator team, addressed through the configuration of the notifiers
+type target struct {
+	Type     string
+	Roles    []string
+	Argument string
+}
+
+func parseTarget(spec string) (target, error) {
+	targetType, argument := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		targetType, argument = spec[:i], spec[i+1:]
+	}
+
+	t := target{Type: targetType}
+	switch targetType {
+	case "space", "org":
+		if argument == "" {
+			break
+		}
+		t.Roles = strings.Split(argument, ",")
+		for _, role := range t.Roles {
+			if targetType == "space" && !spaceRoles[role] || targetType == "org" && !orgRoles[role] {
+				return target{}, fmt.Errorf("unknown %s role %q in target %q", targetType, role, spec)
+			}
+		}
+	case "user", "team":
+		if argument == "" {
+			return target{}, fmt.Errorf("target %q needs a %s", spec, map[string]string{"user": "user guid", "team": "team name"}[targetType])
+		}
+		t.Argument = argument
+	default:
+		return target{}, fmt.Errorf("unknown target type in %q", spec)
+	}
+
+	return t, nil
+}
+
+func parseTargets(specs []string) ([]target, error) {
+	var targets []target
+	for _, spec := range specs {
+		t, err := parseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+func (t target) String() string {
+	switch {
+	case len(t.Roles) > 0:
+		return t.Type + ":" + strings.Join(t.Roles, ",")
+	case t.Argument != "":
+		return t.Type + ":" + t.Argument
+	default:
+		return t.Type
+	}
+}
+
+// retarget addresses msg to t. Notifications for other targets get their own id, so sinks don't see them as
+// duplicates.
+func retarget(msg NotificationMessage, t target) (NotificationMessage, bool) {
+	roles := t.Roles
+	switch t.Type {
+	case "space":
+		msg.Target.Id = msg.Labels.SpaceGuid
+	case "org":
+		msg.Target.Id = msg.Labels.OrgGuid
+		if len(roles) == 0 {
+			roles = []string{"organization_manager"}
+		}
+	case "user", "team":
+		msg.Target.Id = t.Argument
+	}
+	if msg.Target.Id == "" {
+		log.Printf("Notification %s has no %s to send it to\n", strings.TrimSpace(msg.Id), t.Type)
+		return msg, false
+	}
+
+	if msg.Target.Type == "space" && t.String() == "space" {
+		//the default target, keep the id
+		return msg, true
+	}
+
+	msg.Target.Type = t.Type
+	msg.Target.Roles = roles
+	msg.Id = fmt.Sprintf("%s-%s", strings.TrimSpace(msg.Id), t)
+	return msg, true
+}
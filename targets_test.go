@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"space", "space", false},
+		{"space:space_manager,space_developer", "space:space_manager,space_developer", false},
+		{"org", "org", false},
+		{"org:organization_auditor", "org:organization_auditor", false},
+		{"user:user-guid", "user:user-guid", false},
+		{"team:dba", "team:dba", false},
+		{"space:organization_manager", "", true},
+		{"org:space_developer", "", true},
+		{"user", "", true},
+		{"team:", "", true},
+		{"instance", "", true},
+	} {
+		got, err := parseTarget(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error %v", test.spec, err)
+			continue
+		}
+		if err == nil && got.String() != test.want {
+			t.Errorf("%s: parsed as %s", test.spec, got)
+		}
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	msg := testMessage()
+	msg.Id = "guid-1-disk\n"
+	msg.Labels.OrgGuid = "org-guid"
+
+	for _, test := range []struct {
+		spec      string
+		wantId    string
+		wantType  string
+		wantTo    string
+		wantRoles string
+	}{
+		{"space", "guid-1-disk\n", "space", "space-guid", ""},
+		{"space:space_manager", "guid-1-disk-space:space_manager", "space", "space-guid", "space_manager"},
+		{"org", "guid-1-disk-org", "org", "org-guid", "organization_manager"},
+		{"user:user-guid", "guid-1-disk-user:user-guid", "user", "user-guid", ""},
+		{"team:dba", "guid-1-disk-team:dba", "team", "dba", ""},
+	} {
+		target, err := parseTarget(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := retarget(msg, target)
+		if !ok || got.Id != test.wantId || got.Target.Type != test.wantType || got.Target.Id != test.wantTo || strings.Join(got.Target.Roles, ",") != test.wantRoles {
+			t.Errorf("%s: got %q %+v", test.spec, got.Id, got.Target)
+		}
+	}
+
+	//without the org guid there is nobody to send an org notification to
+	msg.Labels.OrgGuid = ""
+	target, _ := parseTarget("org")
+	if _, ok := retarget(msg, target); ok {
+		t.Errorf("notification without org guid was addressed to the org")
+	}
+}
+
+func TestRuleTargetsAreDeliveredSeparately(t *testing.T) {
+	msg := testMessage()
+	msg.Labels.OrgGuid = "org-guid"
+	var err error
+	if msg.Targets, err = parseTargets([]string{"space", "org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := targetDeliveries(msg, nil, []string{"cfns"})
+	if len(deliveries) != 2 || deliveries[0].msg.Target.Id != "space-guid" || deliveries[1].msg.Target.Id != "org-guid" {
+		t.Errorf("unexpected deliveries %+v", deliveries)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type teamsNotifier struct {
+	url        string
+	httpClient http.Client
+}
+
+// teamsMessage is a message with an Adaptive Card, as accepted by Teams incoming webhooks and workflows.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string                `json:"$schema"`
+	Type    string                `json:"type"`
+	Version string                `json:"version"`
+	Body    []adaptiveCardElement `json:"body"`
+	Actions []adaptiveCardAction  `json:"actions,omitempty"`
+	MsTeams map[string]string     `json:"msteams,omitempty"`
+}
+
+// adaptiveCardElement is a TextBlock, a RichTextBlock or a TextRun. TextBlocks are rendered as Markdown by Teams,
+// TextRuns are shown as is.
+type adaptiveCardElement struct {
+	Type     string                `json:"type"`
+	Text     string                `json:"text,omitempty"`
+	Wrap     bool                  `json:"wrap,omitempty"`
+	Weight   string                `json:"weight,omitempty"`
+	Size     string                `json:"size,omitempty"`
+	Color    string                `json:"color,omitempty"`
+	FontType string                `json:"fontType,omitempty"`
+	Inlines  []adaptiveCardElement `json:"inlines,omitempty"`
+}
+
+type adaptiveCardAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func NewTeamsNotifier(config notifierConfig) (*teamsNotifier, error) {
+	if err := requireFields(map[string]string{"url": config.URL}); err != nil {
+		return nil, err
+	}
+
+	return &teamsNotifier{
+		url:        config.URL,
+		httpClient: newNotifierHttpClient(),
+	}, nil
+}
+
+// Send posts an Adaptive Card to a Microsoft Teams incoming webhook.
+func (n *teamsNotifier) Send(msg NotificationMessage) error {
+	color := "Warning"
+	if msg.Labels.Severity == "critical" {
+		color = "Attention"
+	}
+
+	title := literalText(msg.Subject)
+	title.Inlines[0].Weight = "Bolder"
+	title.Inlines[0].Size = "Medium"
+	title.Inlines[0].Color = color
+
+	card := adaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.2",
+		Body:    append([]adaptiveCardElement{title}, adaptiveCardBody(msg)...),
+		MsTeams: map[string]string{"width": "Full"},
+	}
+	if msg.AcknowledgeURL != "" {
+		card.Actions = []adaptiveCardAction{{Type: "Action.OpenUrl", Title: "Acknowledge", URL: msg.AcknowledgeURL}}
+	}
+
+	return postJSON(n.httpClient, n.url, nil, teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content:     card,
+		}},
+	})
+}
+
+// adaptiveCardBody renders the message as card elements. Plain messages are shown as is, Markdown messages are
+// converted to the Markdown subset of TextBlocks: bold, italic, links and lists. Headings become bold text blocks and
+// code is shown as is in a monospace font.
+func adaptiveCardBody(msg NotificationMessage) []adaptiveCardElement {
+	var body []adaptiveCardElement
+	if msg.Format != formatMarkdown {
+		for _, paragraph := range strings.Split(strings.ReplaceAll(msg.Message, "\r\n", "\n"), "\n\n") {
+			if strings.TrimSpace(paragraph) != "" {
+				body = append(body, literalText(paragraph))
+			}
+		}
+		return body
+	}
+
+	style := inlineStyle{
+		escape: identity,
+		code:   identity,
+		link:   func(text, url string) string { return "[" + text + "](" + url + ")" },
+		//bold is marked with a placeholder first, as its asterisks would be taken for italic
+		bold:   func(s string) string { return "\x00" + s + "\x00" },
+		italic: func(s string) string { return "_" + s + "_" },
+		//TextBlocks are Markdown, so escaped characters stay escaped
+		literal: func(c string) string { return "\\" + c },
+	}
+	inline := func(text string) string {
+		return strings.ReplaceAll(style.render(text), "\x00", "**")
+	}
+
+	for _, block := range parseMarkdown(msg.Message) {
+		switch block.kind {
+		case "heading":
+			body = append(body, adaptiveCardElement{Type: "TextBlock", Text: inline(block.lines[0]), Wrap: true, Weight: "Bolder"})
+		case "list", "numbered":
+			var items []string
+			for i, item := range block.lines {
+				bullet := "-"
+				if block.kind == "numbered" {
+					bullet = fmt.Sprintf("%d.", i+1)
+				}
+				items = append(items, bullet+" "+inline(item))
+			}
+			body = append(body, adaptiveCardElement{Type: "TextBlock", Text: strings.Join(items, "\r"), Wrap: true})
+		case "code":
+			code := literalText(strings.Join(block.lines, "\n"))
+			code.Inlines[0].FontType = "Monospace"
+			body = append(body, code)
+		default:
+			body = append(body, adaptiveCardElement{Type: "TextBlock", Text: inline(strings.Join(block.lines, "\n")), Wrap: true})
+		}
+	}
+
+	return body
+}
+
+// literalText returns a RichTextBlock showing text as is, without Markdown.
+func literalText(text string) adaptiveCardElement {
+	return adaptiveCardElement{
+		Type:    "RichTextBlock",
+		Inlines: []adaptiveCardElement{{Type: "TextRun", Text: text}},
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookTimestampHeader = "X-CfServiceAlert-Timestamp"
+	webhookSignatureHeader = "X-CfServiceAlert-Signature"
+)
+
+// webhookNotifier posts alerts as json to a url. When a secret is configured every request is signed
+// with HMAC-SHA256 over "<timestamp>.<body>", so receivers can verify the sender and reject replayed requests by
+// checking the timestamp.
+type webhookNotifier struct {
+	url        string
+	headers    map[string]string
+	secret     []byte
+	httpClient http.Client
+}
+
+type webhookPayload struct {
+	Status       string                    `json:"status"`
+	Environment  string                    `json:"environment"`
+	InstanceGuid string                    `json:"service_instance_guid"`
+	InstanceName string                    `json:"service_instance_name"`
+	Rule         string                    `json:"rule"`
+	Severity     string                    `json:"severity"`
+	Labels       alertLabels               `json:"labels"`
+	Subject      string                    `json:"subject"`
+	Message      string                    `json:"message"`
+	StartsAt     time.Time                 `json:"starts_at"`
+	EndsAt       *time.Time                `json:"ends_at,omitempty"` //only set for resolved alerts
+	Target       NotificationMessageTarget `json:"target"`
+
+	AcknowledgeURL string `json:"acknowledge_url,omitempty"`
+	Format         string `json:"format"`
+	Chart          []byte `json:"chart_png,omitempty"` //base64 encoded
+}
+
+// NewWebhookNotifiers returns a notifier for each of the urls of a webhook, so a url which fails is retried without
+// sending the notification again to the urls which accepted it.
+func NewWebhookNotifiers(config notifierConfig) ([]*webhookNotifier, error) {
+	urls := config.URLs
+	if config.URL != "" {
+		urls = append([]string{config.URL}, urls...)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("url or urls is required")
+	}
+
+	var webhooks []*webhookNotifier
+	for _, url := range urls {
+		webhooks = append(webhooks, &webhookNotifier{
+			url:        url,
+			headers:    config.Headers,
+			secret:     []byte(config.Secret),
+			httpClient: newNotifierHttpClient(),
+		})
+	}
+
+	return webhooks, nil
+}
+
+func (n *webhookNotifier) Send(msg NotificationMessage) error {
+	payload := webhookPayload{
+		Status:       msg.Status,
+		Environment:  msg.Target.Environment,
+		InstanceGuid: msg.Labels.InstanceGuid,
+		InstanceName: msg.Labels.InstanceName,
+		Rule:         msg.Labels.Rule,
+		Severity:     msg.Labels.Severity,
+		Labels:       msg.Labels,
+		Subject:      msg.Subject,
+		Message:      msg.Message,
+		StartsAt:     msg.StartsAt,
+		Target:       msg.Target,
+
+		AcknowledgeURL: msg.AcknowledgeURL,
+		Format:         formatOrPlain(msg.Format),
+		Chart:          msg.Chart,
+	}
+	if msg.Status == statusResolved && !msg.EndsAt.IsZero() {
+		payload.EndsAt = &msg.EndsAt
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error marshalling message to json: %v", err)
+	}
+
+	headers := make(map[string]string)
+	for k, v := range n.headers {
+		headers[k] = v
+	}
+
+	if len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers[webhookTimestampHeader] = timestamp
+		headers[webhookSignatureHeader] = "sha256=" + signWebhook(n.secret, timestamp, body)
+	}
+
+	return postBody(n.httpClient, n.url, headers, body)
+}
+
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+
+	webhooks, err := NewWebhookNotifiers(notifierConfig{URL: server.URL, Secret: "s3cret", Headers: map[string]string{"X-Api-Key": "key"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhooks[0].Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+
+	timestamp := req.header.Get(webhookTimestampHeader)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Errorf("invalid timestamp header %q", timestamp)
+	}
+	if got, want := req.header.Get(webhookSignatureHeader), "sha256="+signWebhook([]byte("s3cret"), timestamp, req.body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got := req.header.Get("X-Api-Key"); got != "key" {
+		t.Errorf("X-Api-Key = %q, want key", got)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Status != statusFiring || payload.InstanceGuid != "guid-1" || payload.Format != formatPlain {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestWebhookWithoutSecretIsNotSigned(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+
+	webhooks, _ := NewWebhookNotifiers(notifierConfig{URL: server.URL})
+	if err := webhooks[0].Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := server.requests()[0].header.Get(webhookSignatureHeader); got != "" {
+		t.Errorf("unexpected signature %q", got)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	//printf '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	want := "b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := signWebhook([]byte("secret"), "1700000000", []byte("{}")); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookUrlsAreSeparateSinks(t *testing.T) {
+	accepting := newStandIn(t, http.StatusOK)
+	failing := newStandIn(t, http.StatusServiceUnavailable)
+
+	sinks, err := NewNotifiers([]notifierConfig{{Name: "hooks", Type: "webhook", URLs: []string{accepting.URL, failing.URL}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 2 || sinks[0].id != "hooks#0" || sinks[1].id != "hooks#1" || sinks[0].name != "hooks" {
+		t.Fatalf("unexpected sinks %+v", sinks)
+	}
+
+	if err := sinks[0].Send(testMessage()); err != nil {
+		t.Errorf("accepting url: %v", err)
+	}
+	if err := sinks[1].Send(testMessage()); err == nil || isPermanent(err) {
+		t.Errorf("failing url: got %v, want a retryable error", err)
+	}
+	if got := len(accepting.requests()); got != 1 {
+		t.Errorf("accepting url got %d requests, want 1", got)
+	}
+}
+
+func TestWebhookEndsAt(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	webhooks, _ := NewWebhookNotifiers(notifierConfig{URL: server.URL})
+
+	resolved := testMessage()
+	resolved.Status = statusResolved
+	resolved.EndsAt = time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	for _, msg := range []NotificationMessage{testMessage(), resolved} {
+		if err := webhooks[0].Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var payloads []map[string]interface{}
+	for _, req := range server.requests() {
+		var payload map[string]interface{}
+		if err := json.Unmarshal(req.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	if endsAt, ok := payloads[0]["ends_at"]; ok {
+		t.Errorf("firing alert has ends_at %v", endsAt)
+	}
+	if endsAt := payloads[1]["ends_at"]; endsAt != "2024-05-01T13:00:00Z" {
+		t.Errorf("resolved alert has ends_at %v, want 2024-05-01T13:00:00Z", endsAt)
+	}
+}